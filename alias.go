@@ -0,0 +1,150 @@
+package booru
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// database statements
+const (
+	StatementCreateAliases    = "create table aliases (antecedent text not null primary key, tag text not null)"
+	StatementQueryAlias       = "select tag from aliases where antecedent = ?"
+	StatementQueryAliases     = "select antecedent, tag from aliases order by antecedent"
+	StatementQueryAliasTarget = "select count(*) from aliases where tag = ?"
+	StatementInsertAlias      = "insert into aliases (antecedent, tag) values (?, ?)"
+	StatementDeleteAlias      = "delete from aliases where antecedent = ?"
+	StatementCopyRelations    = "insert or ignore into relations (post, tag) select post, ? from relations where tag = ?"
+	StatementDeleteRelations  = "delete from relations where tag = ?"
+)
+
+// errors
+var (
+	ErrorDuplicateAlias = errors.New("duplicate alias")
+	ErrorInvalidAlias   = errors.New("invalid alias")
+)
+
+// satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}
+
+// resolve a tag to its canonical form
+func canonicalTag(ctx context.Context, db querier, tag string) (canonical string, err error) {
+	err = db.QueryRowContext(ctx, StatementQueryAlias, tag).Scan(&canonical)
+	if err == sql.ErrNoRows {
+		canonical, err = tag, nil
+	}
+	return
+}
+
+// Alias antecedent to tag: searches for and new uses of antecedent refer to
+// tag instead, and posts already tagged with antecedent are retagged. Aliases
+// do not chain, so neither tag may already take part in an alias in the
+// conflicting direction.
+func (b *Booru) AddAlias(ctx context.Context, antecedent, tag string) (err error) {
+	var transaction *sql.Tx
+	if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer transaction.Rollback()
+
+	if err = b.addAlias(ctx, transaction, antecedent, tag); err != nil {
+		return
+	}
+
+	if err = transaction.Commit(); err != nil {
+		return
+	}
+
+	// relations were moved wholesale
+	if err = b.memory.reload(ctx, b.db, antecedent, tag); err != nil {
+		return
+	}
+
+	return b.invalidateIndexes(antecedent, tag)
+}
+
+func (b *Booru) addAlias(ctx context.Context, transaction *sql.Tx, antecedent, tag string) (err error) {
+	if antecedent == tag {
+		return ErrorInvalidAlias
+	}
+
+	var canonical string
+	if canonical, err = canonicalTag(ctx, transaction, antecedent); err != nil {
+		return
+	} else if canonical != antecedent {
+		return ErrorDuplicateAlias
+	}
+
+	if canonical, err = canonicalTag(ctx, transaction, tag); err != nil {
+		return
+	} else if canonical != tag {
+		return ErrorInvalidAlias
+	}
+
+	var targeted int
+	if err = transaction.QueryRowContext(ctx, StatementQueryAliasTarget, antecedent).Scan(&targeted); err != nil {
+		return
+	} else if targeted > 0 {
+		return ErrorInvalidAlias
+	}
+
+	if _, err = transaction.ExecContext(ctx, StatementInsertAlias, antecedent, tag); err != nil {
+		return
+	}
+
+	// move existing relations over to the canonical tag
+	var antecedentID int64
+	err = transaction.QueryRowContext(ctx, StatementQueryTagID, antecedent).Scan(&antecedentID)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return
+	}
+
+	var tagID int64
+	if tagID, err = b.tagID(ctx, transaction, tag); err != nil {
+		return
+	}
+	if _, err = transaction.ExecContext(ctx, StatementCopyRelations, tagID, antecedentID); err != nil {
+		return
+	}
+	_, err = transaction.ExecContext(ctx, StatementDeleteRelations, antecedentID)
+
+	return
+}
+
+// Remove the alias of antecedent. Posts retagged when the alias was added
+// keep the canonical tag.
+func (b *Booru) RemoveAlias(ctx context.Context, antecedent string) (err error) {
+	_, err = b.db.ExecContext(ctx, StatementDeleteAlias, antecedent)
+	return
+}
+
+// Get every alias, keyed by antecedent.
+func (b *Booru) Aliases(ctx context.Context) (aliases map[string]string, err error) {
+	return queryAliases(ctx, b.db)
+}
+
+func queryAliases(ctx context.Context, db querier) (aliases map[string]string, err error) {
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, StatementQueryAliases); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	aliases = make(map[string]string)
+	for rows.Next() {
+		var antecedent, tag string
+		if err = rows.Scan(&antecedent, &tag); err != nil {
+			return
+		}
+		aliases[antecedent] = tag
+	}
+
+	err = rows.Err()
+
+	return
+}
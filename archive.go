@@ -0,0 +1,379 @@
+package booru
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The archive format is newline delimited JSON, one record per line. Each
+// record has a "type"; the first is a header and the rest may appear in any
+// order, though Export writes them in the order below so that aliases are
+// known before the posts which use them:
+//
+//	{"type":"header","version":1}
+//	{"type":"namespace","namespace":"artist","description":"..."}
+//	{"type":"alias","antecedent":"cats","tag":"cat"}
+//	{"type":"implication","tag":"cat","implies":"animal"}
+//	{"type":"rule","pattern":"^art/([^/]+)/","tags":["artist:$1"]}
+//	{"type":"baseline","name":"safe","query":"-nsfw"}
+//	{"type":"post","post":"art/a.png","time":"2021-06-01T12:00:00Z","tags":["cat"],
+//	 "metadata":{"Hash":"...","Size":1,"MIME":"image/png","Width":1,"Height":1,"PHash":0},
+//	 "locations":["copies/a.png"]}
+//
+// Posts are identified by path, and tags by name.
+const ArchiveVersion = 1
+
+// database statements
+const (
+	StatementQueryLocations  = "select post, location from locations order by location"
+	StatementQueryRuleExists = "select count(*) from rules where pattern = ? and tags = ?"
+	StatementMergeNamespace  = "insert into namespaces (namespace, description) values (?, ?) on conflict (namespace) do update set description = excluded.description where namespaces.description = ''"
+	StatementQueryPathPostID = "select id from posts where post = ? union select post from locations where location = ?"
+)
+
+// errors
+var (
+	ErrorArchiveVersion = errors.New("unsupported archive version")
+	ErrorArchiveRecord  = errors.New("unknown archive record")
+)
+
+type archiveRecord struct {
+	Type string `json:"type"`
+
+	Version int `json:"version,omitempty"` // header
+
+	Namespace   string `json:"namespace,omitempty"` // namespace
+	Description string `json:"description,omitempty"`
+
+	Antecedent string `json:"antecedent,omitempty"` // alias
+	Tag        string `json:"tag,omitempty"`        // alias, implication
+	Implies    string `json:"implies,omitempty"`    // implication
+
+	Pattern string `json:"pattern,omitempty"` // rule
+
+	Name  string `json:"name,omitempty"` // baseline
+	Query string `json:"query,omitempty"`
+
+	Post      string     `json:"post,omitempty"` // post
+	Time      *time.Time `json:"time,omitempty"`
+	Metadata  *Metadata  `json:"metadata,omitempty"`
+	Locations []string   `json:"locations,omitempty"`
+
+	Tags []string `json:"tags,omitempty"` // rule, post
+}
+
+// Write the whole library (everything in the database, and the baseline
+// queries) to w as an archive.
+func (b *Booru) Export(ctx context.Context, w io.Writer) (err error) {
+	var transaction *sql.Tx
+	if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer transaction.Rollback()
+
+	buffer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffer)
+
+	if err = encoder.Encode(archiveRecord{Type: "header", Version: ArchiveVersion}); err != nil {
+		return
+	}
+
+	var namespaces []Namespace
+	if namespaces, err = queryNamespaces(ctx, transaction); err != nil {
+		return
+	}
+	for _, namespace := range namespaces {
+		if err = encoder.Encode(archiveRecord{Type: "namespace", Namespace: namespace.Namespace, Description: namespace.Description}); err != nil {
+			return
+		}
+	}
+
+	var aliases map[string]string
+	if aliases, err = queryAliases(ctx, transaction); err != nil {
+		return
+	}
+	antecedents := make([]string, 0, len(aliases))
+	for antecedent := range aliases {
+		antecedents = append(antecedents, antecedent)
+	}
+	sort.Strings(antecedents)
+	for _, antecedent := range antecedents {
+		if err = encoder.Encode(archiveRecord{Type: "alias", Antecedent: antecedent, Tag: aliases[antecedent]}); err != nil {
+			return
+		}
+	}
+
+	var implications []Implication
+	if implications, err = queryImplications(ctx, transaction); err != nil {
+		return
+	}
+	for _, implication := range implications {
+		if err = encoder.Encode(archiveRecord{Type: "implication", Tag: implication.Tag, Implies: implication.Implies}); err != nil {
+			return
+		}
+	}
+
+	var rules []Rule
+	if rules, err = queryRules(ctx, transaction); err != nil {
+		return
+	}
+	for _, rule := range rules {
+		if err = encoder.Encode(archiveRecord{Type: "rule", Pattern: rule.Pattern, Tags: rule.Tags}); err != nil {
+			return
+		}
+	}
+
+	if err = b.exportBaselines(encoder); err != nil {
+		return
+	}
+
+	var locations map[int64][]string
+	if locations, err = postLocations(ctx, transaction); err != nil {
+		return
+	}
+
+	var posts []Post
+	if posts, err = scanPosts(ctx, transaction, StatementQueryEveryPostPath); err != nil {
+		return
+	}
+	if err = b.loadTags(ctx, transaction, posts); err != nil {
+		return
+	}
+	for _, post := range posts {
+		record := archiveRecord{
+			Type:      "post",
+			Post:      post.Post,
+			Time:      &post.Time,
+			Metadata:  &post.Metadata,
+			Locations: locations[post.ID],
+		}
+		for _, tag := range post.Tags {
+			record.Tags = append(record.Tags, tag.Tag)
+		}
+
+		if err = encoder.Encode(record); err != nil {
+			return
+		}
+	}
+
+	if err = buffer.Flush(); err != nil {
+		return
+	}
+
+	return transaction.Commit()
+}
+
+func postLocations(ctx context.Context, db querier) (locations map[int64][]string, err error) {
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, StatementQueryLocations); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	locations = make(map[int64][]string)
+	for rows.Next() {
+		var post int64
+		var location string
+		if err = rows.Scan(&post, &location); err != nil {
+			return
+		}
+		locations[post] = append(locations[post], location)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+func (b *Booru) exportBaselines(encoder *json.Encoder) error {
+	return filepath.WalkDir(b.baseline, func(path string, entry fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == b.baseline {
+			return nil
+		} else if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+
+		var name string
+		if name, err = filepath.Rel(b.baseline, path); err != nil {
+			return err
+		}
+
+		var query []byte
+		if query, err = os.ReadFile(path); err != nil {
+			return err
+		}
+
+		return encoder.Encode(archiveRecord{Type: "baseline", Name: filepath.ToSlash(name), Query: string(query)})
+	})
+}
+
+// Merge an archive into the library in a single transaction. Posts already
+// in the library (by path or location) gain the archived tags and locations;
+// the library's own aliases, implications and baseline files win over
+// conflicting ones in the archive.
+func (b *Booru) Import(ctx context.Context, r io.Reader) (err error) {
+	var transaction *sql.Tx
+	if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer transaction.Rollback()
+
+	decoder := json.NewDecoder(r)
+
+	var header archiveRecord
+	if err = decoder.Decode(&header); err != nil {
+		return
+	}
+	if header.Type != "header" || header.Version != ArchiveVersion {
+		return ErrorArchiveVersion
+	}
+
+	var baselines []archiveRecord
+	for line := 2; decoder.More(); line++ {
+		var record archiveRecord
+		if err = decoder.Decode(&record); err != nil {
+			return
+		}
+
+		switch record.Type {
+		case "namespace":
+			_, err = transaction.ExecContext(ctx, StatementMergeNamespace, record.Namespace, record.Description)
+		case "alias":
+			if err = b.addAlias(ctx, transaction, record.Antecedent, record.Tag); err == ErrorDuplicateAlias || err == ErrorInvalidAlias {
+				err = nil
+			}
+		case "implication":
+			if err = b.addImplication(ctx, transaction, record.Tag, record.Implies); err == ErrorDuplicateImplication || err == ErrorImplicationCycle {
+				err = nil
+			}
+		case "rule":
+			err = importRule(ctx, transaction, record)
+		case "baseline":
+			// written once the database changes are committed
+			baselines = append(baselines, record)
+		case "post":
+			err = b.importPost(ctx, transaction, record)
+		default:
+			err = ErrorArchiveRecord
+		}
+		if err != nil {
+			return fmt.Errorf("archive record %d: %w", line, err)
+		}
+	}
+
+	if err = transaction.Commit(); err != nil {
+		return
+	}
+
+	for _, baseline := range baselines {
+		if err = b.importBaseline(baseline); err != nil {
+			return
+		}
+	}
+
+	return b.clearIndexes(ctx)
+}
+
+func importRule(ctx context.Context, transaction *sql.Tx, record archiveRecord) (err error) {
+	tags := strings.Join(record.Tags, " ")
+
+	var count int
+	if err = transaction.QueryRowContext(ctx, StatementQueryRuleExists, record.Pattern, tags).Scan(&count); err != nil || count > 0 {
+		return
+	}
+
+	_, err = transaction.ExecContext(ctx, StatementInsertRule, record.Pattern, tags)
+
+	return
+}
+
+func (b *Booru) importPost(ctx context.Context, transaction *sql.Tx, record archiveRecord) (err error) {
+	var id int64
+	err = transaction.QueryRowContext(ctx, StatementQueryPathPostID, record.Post, record.Post).Scan(&id)
+	if err == sql.ErrNoRows {
+		var metadata Metadata
+		if record.Metadata != nil {
+			metadata = *record.Metadata
+		}
+		var timestamp time.Time
+		if record.Time != nil {
+			timestamp = *record.Time
+		}
+
+		if id, err = b.addPost(ctx, transaction, record.Post, timestamp, metadata); err != nil {
+			return
+		}
+	} else if err != nil {
+		return
+	}
+
+	for _, location := range record.Locations {
+		var existing int64
+		err = transaction.QueryRowContext(ctx, StatementQueryPathPostID, location, location).Scan(&existing)
+		if err == nil {
+			continue
+		} else if err != sql.ErrNoRows {
+			return
+		}
+		if _, err = transaction.ExecContext(ctx, StatementInsertLocation, id, location); err != nil {
+			return
+		}
+	}
+
+	for _, tag := range record.Tags {
+		if err = importTag(ctx, transaction, id, tag); err != nil && err != ErrorDuplicateTag {
+			return
+		}
+	}
+
+	return nil
+}
+
+// attach an archived tag by name: it was stored once already, so it is kept
+// even if its namespace has been reserved since
+func importTag(ctx context.Context, transaction *sql.Tx, id int64, tag string) (err error) {
+	var canonical string
+	if canonical, err = canonicalTag(ctx, transaction, tag); err != nil {
+		return
+	}
+
+	var tagID int64
+	if err = transaction.QueryRowContext(ctx, StatementQueryTagID, canonical).Scan(&tagID); err == sql.ErrNoRows {
+		tagID, err = insertTag(ctx, transaction, canonical)
+	}
+	if err != nil {
+		return
+	}
+
+	return relateTag(ctx, transaction, id, tagID)
+}
+
+func (b *Booru) importBaseline(record archiveRecord) (err error) {
+	path := filepath.Join(b.baseline, filepath.FromSlash(record.Name))
+	if rel, relErr := filepath.Rel(b.baseline, path); relErr != nil || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("baseline %q is outside the baseline directory", record.Name)
+	}
+
+	if _, err = os.Stat(path); err == nil {
+		return
+	} else if !os.IsNotExist(err) {
+		return
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+
+	return os.WriteFile(path, []byte(record.Query), 0644)
+}
@@ -0,0 +1,46 @@
+package booru
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// a library with a tag in a namespace reserved since it was added survives an
+// export and import
+func TestArchiveRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	b := testBooru(t)
+	ids := seedPosts(t, b, 10, func(i int) []string { return []string{"a"} })
+
+	result, err := b.db.ExecContext(ctx, StatementInsertTag, "date:2020", "date")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids[:3] {
+		if _, err = b.db.ExecContext(ctx, StatementInsertRelation, id, tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var archive bytes.Buffer
+	if err = b.Export(ctx, &archive); err != nil {
+		t.Fatal(err)
+	}
+
+	imported := testBooru(t)
+	if err = imported.Import(ctx, &archive); err != nil {
+		t.Fatal(err)
+	}
+
+	for query, want := range map[string]int{"a": 10, `"date:2020"`: 3} {
+		if got := allPages(t, imported, query, 100); len(got) != want {
+			t.Errorf("%s: got %d posts, want %d", query, len(got), want)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package booru
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// A bitmap is a compressed set of post IDs, in the manner of a roaring
+// bitmap: IDs are grouped by their high bits into containers of 1<<16, each
+// either a sorted array of the low bits while it is sparse, or a bitset once
+// it is dense. A bitmap is not changed once built, so the results of set
+// operations (and of with and without) may share containers with their
+// operands, and a bitmap may be read while a changed copy is being made.
+type bitmap struct {
+	keys       []int64 // sorted
+	containers []*container
+}
+
+// the most IDs a container holds as an array; past this a bitset is smaller
+const arrayContainerMax = 4096
+
+const bitsetWords = 1 << 16 / 64
+
+type container struct {
+	array  []uint16 // sorted; used when bitset is nil
+	bitset []uint64
+	n      int
+}
+
+func newBitmap() *bitmap {
+	return &bitmap{}
+}
+
+func splitID(id int64) (key int64, low uint16) {
+	return id >> 16, uint16(id)
+}
+
+// the container for key, creating it if asked
+func (b *bitmap) container(key int64, create bool) *container {
+	i := b.index(key)
+	if i < len(b.keys) && b.keys[i] == key {
+		return b.containers[i]
+	}
+	if !create {
+		return nil
+	}
+
+	c := &container{}
+	b.keys = append(b.keys, 0)
+	copy(b.keys[i+1:], b.keys[i:])
+	b.keys[i] = key
+	b.containers = append(b.containers, nil)
+	copy(b.containers[i+1:], b.containers[i:])
+	b.containers[i] = c
+	return c
+}
+
+// add an ID to a bitmap which is still being built
+func (b *bitmap) add(id int64) {
+	key, low := splitID(id)
+	b.container(key, true).add(low)
+}
+
+func (b *bitmap) contains(id int64) bool {
+	key, low := splitID(id)
+	c := b.container(key, false)
+	return c != nil && c.contains(low)
+}
+
+// a copy of b (which may be nil) with id added
+func (b *bitmap) with(id int64) *bitmap {
+	if b == nil {
+		b = newBitmap()
+	}
+	if b.contains(id) {
+		return b
+	}
+
+	result := b.copy()
+	key, low := splitID(id)
+	i := result.index(key)
+	if i < len(result.keys) && result.keys[i] == key {
+		result.containers[i] = result.containers[i].clone()
+	}
+	result.container(key, true).add(low)
+	return result
+}
+
+// a copy of b (which may be nil) with id removed
+func (b *bitmap) without(id int64) *bitmap {
+	if b == nil || !b.contains(id) {
+		return b
+	}
+
+	result := b.copy()
+	key, low := splitID(id)
+	i := result.index(key)
+	c := result.containers[i].clone()
+	c.remove(low)
+	if c.n > 0 {
+		result.containers[i] = c
+		return result
+	}
+
+	result.keys = append(result.keys[:i], result.keys[i+1:]...)
+	result.containers = append(result.containers[:i], result.containers[i+1:]...)
+	return result
+}
+
+// a copy of the key and container lists, sharing the containers
+func (b *bitmap) copy() *bitmap {
+	return &bitmap{append([]int64{}, b.keys...), append([]*container{}, b.containers...)}
+}
+
+// the position of the first key not less than key
+func (b *bitmap) index(key int64) int {
+	return sort.Search(len(b.keys), func(i int) bool { return b.keys[i] >= key })
+}
+
+func (b *bitmap) cardinality() (n int) {
+	for _, c := range b.containers {
+		n += c.n
+	}
+	return
+}
+
+// call each with every ID in the set, in ascending order, until it returns
+// false
+func (b *bitmap) each(each func(int64) bool) {
+	b.eachFrom(math.MinInt64, each)
+}
+
+// as each, starting from the first ID not less than from
+func (b *bitmap) eachFrom(from int64, each func(int64) bool) {
+	fromKey, fromLow := splitID(from)
+	for i := b.index(fromKey); i < len(b.keys); i++ {
+		high := b.keys[i] << 16
+
+		start := uint16(0)
+		if b.keys[i] == fromKey {
+			start = fromLow
+		}
+
+		if !b.containers[i].each(start, func(low uint16) bool { return each(high | int64(low)) }) {
+			return
+		}
+	}
+}
+
+// the IDs in both a and b
+func and(a, b *bitmap) *bitmap {
+	result := newBitmap()
+	for i, j := 0, 0; i < len(a.keys) && j < len(b.keys); {
+		switch {
+		case a.keys[i] < b.keys[j]:
+			i++
+		case a.keys[i] > b.keys[j]:
+			j++
+		default:
+			if c := a.containers[i].and(b.containers[j]); c.n > 0 {
+				result.keys = append(result.keys, a.keys[i])
+				result.containers = append(result.containers, c)
+			}
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// the IDs in either a or b
+func or(a, b *bitmap) *bitmap {
+	result := newBitmap()
+	i, j := 0, 0
+	for i < len(a.keys) || j < len(b.keys) {
+		switch {
+		case j == len(b.keys) || (i < len(a.keys) && a.keys[i] < b.keys[j]):
+			result.keys = append(result.keys, a.keys[i])
+			result.containers = append(result.containers, a.containers[i])
+			i++
+		case i == len(a.keys) || a.keys[i] > b.keys[j]:
+			result.keys = append(result.keys, b.keys[j])
+			result.containers = append(result.containers, b.containers[j])
+			j++
+		default:
+			result.keys = append(result.keys, a.keys[i])
+			result.containers = append(result.containers, a.containers[i].or(b.containers[j]))
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// the IDs in a but not b
+func andNot(a, b *bitmap) *bitmap {
+	result := newBitmap()
+	j := 0
+	for i, key := range a.keys {
+		for j < len(b.keys) && b.keys[j] < key {
+			j++
+		}
+
+		c := a.containers[i]
+		if j < len(b.keys) && b.keys[j] == key {
+			c = c.andNot(b.containers[j])
+		}
+		if c.n > 0 {
+			result.keys = append(result.keys, key)
+			result.containers = append(result.containers, c)
+		}
+	}
+	return result
+}
+
+func (c *container) add(low uint16) {
+	if c.bitset != nil {
+		if c.bitset[low/64]&(1<<(low%64)) == 0 {
+			c.bitset[low/64] |= 1 << (low % 64)
+			c.n++
+		}
+		return
+	}
+
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= low })
+	if i < len(c.array) && c.array[i] == low {
+		return
+	}
+	c.array = append(c.array, 0)
+	copy(c.array[i+1:], c.array[i:])
+	c.array[i] = low
+	c.n++
+
+	if c.n > arrayContainerMax {
+		c.toBitset()
+	}
+}
+
+func (c *container) remove(low uint16) {
+	if c.bitset != nil {
+		if c.bitset[low/64]&(1<<(low%64)) != 0 {
+			c.bitset[low/64] &^= 1 << (low % 64)
+			c.n--
+		}
+		if c.n <= arrayContainerMax {
+			c.toArray()
+		}
+		return
+	}
+
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= low })
+	if i < len(c.array) && c.array[i] == low {
+		c.array = append(c.array[:i], c.array[i+1:]...)
+		c.n--
+	}
+}
+
+func (c *container) clone() *container {
+	if c.bitset != nil {
+		return &container{bitset: append([]uint64{}, c.bitset...), n: c.n}
+	}
+	return &container{array: append([]uint16{}, c.array...), n: c.n}
+}
+
+func (c *container) contains(low uint16) bool {
+	if c.bitset != nil {
+		return c.bitset[low/64]&(1<<(low%64)) != 0
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= low })
+	return i < len(c.array) && c.array[i] == low
+}
+
+// call each with the members from start on, in order, until it returns
+// false; reports whether it went through them all
+func (c *container) each(start uint16, each func(uint16) bool) bool {
+	if c.bitset == nil {
+		i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= start })
+		for _, low := range c.array[i:] {
+			if !each(low) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i := int(start / 64); i < len(c.bitset); i++ {
+		word := c.bitset[i]
+		if i == int(start/64) {
+			word &^= 1<<(start%64) - 1
+		}
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			if !each(uint16(i*64 + bit)) {
+				return false
+			}
+			word &= word - 1
+		}
+	}
+	return true
+}
+
+func (c *container) toBitset() {
+	c.bitset = make([]uint64, bitsetWords)
+	for _, low := range c.array {
+		c.bitset[low/64] |= 1 << (low % 64)
+	}
+	c.array = nil
+}
+
+func (c *container) toArray() {
+	array := make([]uint16, 0, c.n)
+	c.each(0, func(low uint16) bool {
+		array = append(array, low)
+		return true
+	})
+	c.array, c.bitset = array, nil
+}
+
+// the bitset of c, which must not be changed, whatever c is now
+func (c *container) bitsetOf() []uint64 {
+	if c.bitset != nil {
+		return c.bitset
+	}
+	bitset := make([]uint64, bitsetWords)
+	for _, low := range c.array {
+		bitset[low/64] |= 1 << (low % 64)
+	}
+	return bitset
+}
+
+// a container from the result of a bitset operation, as an array if it is
+// sparse enough
+func bitsetContainer(bitset []uint64) *container {
+	c := &container{bitset: bitset}
+	for _, word := range bitset {
+		c.n += bits.OnesCount64(word)
+	}
+	if c.n <= arrayContainerMax {
+		c.toArray()
+	}
+	return c
+}
+
+func (c *container) and(o *container) *container {
+	// a sparse side is cheapest to probe the other with
+	if c.bitset == nil || o.bitset == nil {
+		small, large := c, o
+		if small.bitset != nil {
+			small, large = o, c
+		}
+
+		result := &container{}
+		for _, low := range small.array {
+			if large.contains(low) {
+				result.array = append(result.array, low)
+			}
+		}
+		result.n = len(result.array)
+		return result
+	}
+
+	bitset := make([]uint64, bitsetWords)
+	for i := range bitset {
+		bitset[i] = c.bitset[i] & o.bitset[i]
+	}
+	return bitsetContainer(bitset)
+}
+
+func (c *container) or(o *container) *container {
+	if c.bitset == nil && o.bitset == nil && c.n+o.n <= arrayContainerMax {
+		result := &container{array: make([]uint16, 0, c.n+o.n)}
+		i, j := 0, 0
+		for i < len(c.array) || j < len(o.array) {
+			switch {
+			case j == len(o.array) || (i < len(c.array) && c.array[i] < o.array[j]):
+				result.array = append(result.array, c.array[i])
+				i++
+			case i == len(c.array) || c.array[i] > o.array[j]:
+				result.array = append(result.array, o.array[j])
+				j++
+			default:
+				result.array = append(result.array, c.array[i])
+				i++
+				j++
+			}
+		}
+		result.n = len(result.array)
+		return result
+	}
+
+	bitset := append([]uint64{}, c.bitsetOf()...)
+	for i, word := range o.bitsetOf() {
+		bitset[i] |= word
+	}
+	return bitsetContainer(bitset)
+}
+
+func (c *container) andNot(o *container) *container {
+	if c.bitset == nil {
+		result := &container{}
+		for _, low := range c.array {
+			if !o.contains(low) {
+				result.array = append(result.array, low)
+			}
+		}
+		result.n = len(result.array)
+		return result
+	}
+
+	bitset := append([]uint64{}, c.bitset...)
+	for i, word := range o.bitsetOf() {
+		bitset[i] &^= word
+	}
+	return bitsetContainer(bitset)
+}
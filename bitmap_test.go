@@ -0,0 +1,220 @@
+package booru
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// a bitmap and the plain set it should hold
+func testBitmap(ids []int64) (*bitmap, map[int64]bool) {
+	b, set := newBitmap(), map[int64]bool{}
+	for _, id := range ids {
+		b.add(id)
+		set[id] = true
+	}
+	return b, set
+}
+
+func bitmapIDs(b *bitmap) (ids []int64) {
+	b.each(func(id int64) bool {
+		ids = append(ids, id)
+		return true
+	})
+	return
+}
+
+func setIDs(set map[int64]bool) (ids []int64) {
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return
+}
+
+// every ID in [from, to) at the given step
+func idRange(from, to, step int64) (ids []int64) {
+	for id := from; id < to; id += step {
+		ids = append(ids, id)
+	}
+	return
+}
+
+func randomIDs(r *rand.Rand, count int, from, to int64) (ids []int64) {
+	for i := 0; i < count; i++ {
+		ids = append(ids, from+r.Int63n(to-from))
+	}
+	return
+}
+
+func TestBitmapContainers(t *testing.T) {
+	tests := []struct {
+		name   string
+		ids    []int64
+		bitset bool
+	}{
+		{"empty", nil, false},
+		{"array at the limit", idRange(0, arrayContainerMax, 1), false},
+		{"bitset past the limit", idRange(0, arrayContainerMax+1, 1), true},
+		{"sparse", idRange(0, 1<<16, 1<<8), false},
+		{"dense", idRange(0, 1<<16, 2), true},
+	}
+
+	for _, test := range tests {
+		b, set := testBitmap(test.ids)
+
+		if got := bitmapIDs(b); !reflect.DeepEqual(got, setIDs(set)) {
+			t.Errorf("%s: got %d ids, want %d", test.name, len(got), len(set))
+		}
+		if b.cardinality() != len(set) {
+			t.Errorf("%s: cardinality %d, want %d", test.name, b.cardinality(), len(set))
+		}
+		if len(b.containers) > 0 && (b.containers[0].bitset != nil) != test.bitset {
+			t.Errorf("%s: bitset %v, want %v", test.name, b.containers[0].bitset != nil, test.bitset)
+		}
+	}
+}
+
+func TestBitmapOperations(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	tests := []struct {
+		name string
+		a, b []int64
+	}{
+		{"empty", nil, nil},
+		{"one empty", idRange(0, 100, 1), nil},
+		{"array and array", randomIDs(r, 1000, 0, 1<<16), randomIDs(r, 1000, 0, 1<<16)},
+		{"array and bitset", randomIDs(r, 500, 0, 1<<16), idRange(0, 1<<16, 3)},
+		{"bitset and array", idRange(0, 1<<16, 3), randomIDs(r, 500, 0, 1<<16)},
+		{"bitset and bitset", idRange(0, 1<<16, 2), idRange(0, 1<<16, 3)},
+		{"bitsets to an array", idRange(0, 1<<16, 2), idRange(1, 1<<16, 2)},
+		{"union past the limit", idRange(0, 3000, 1), idRange(3000, 6000, 1)},
+		{"several containers", randomIDs(r, 20000, 0, 1<<20), randomIDs(r, 20000, 0, 1<<20)},
+		{"disjoint containers", idRange(0, 100, 1), idRange(1<<17, 1<<17+100, 1)},
+		{"negative", randomIDs(r, 5000, -1<<18, 1<<18), randomIDs(r, 5000, -1<<18, 1<<18)},
+	}
+
+	for _, test := range tests {
+		a, aSet := testBitmap(test.a)
+		b, bSet := testBitmap(test.b)
+
+		wantAnd, wantOr, wantAndNot := map[int64]bool{}, map[int64]bool{}, map[int64]bool{}
+		for id := range aSet {
+			wantOr[id] = true
+			if bSet[id] {
+				wantAnd[id] = true
+			} else {
+				wantAndNot[id] = true
+			}
+		}
+		for id := range bSet {
+			wantOr[id] = true
+		}
+
+		for _, result := range []struct {
+			operation string
+			got       *bitmap
+			want      map[int64]bool
+		}{
+			{"and", and(a, b), wantAnd},
+			{"or", or(a, b), wantOr},
+			{"andNot", andNot(a, b), wantAndNot},
+		} {
+			if got := bitmapIDs(result.got); !reflect.DeepEqual(got, setIDs(result.want)) {
+				t.Errorf("%s: %s: got %d ids, want %d", test.name, result.operation, len(got), len(result.want))
+			}
+			if result.got.cardinality() != len(result.want) {
+				t.Errorf("%s: %s: cardinality %d, want %d", test.name, result.operation, result.got.cardinality(), len(result.want))
+			}
+		}
+
+		// the operands are shared, never changed
+		if got := bitmapIDs(a); !reflect.DeepEqual(got, setIDs(aSet)) {
+			t.Errorf("%s: operand changed", test.name)
+		}
+	}
+}
+
+func TestBitmapWithWithout(t *testing.T) {
+	tests := []struct {
+		name string
+		ids  []int64
+		id   int64
+	}{
+		{"into nothing", nil, 5},
+		{"into an array", idRange(0, 100, 2), 51},
+		{"array to bitset", idRange(0, arrayContainerMax*2, 2), 1},
+		{"new container", idRange(0, 100, 1), 1 << 20},
+		{"negative", idRange(-100, 0, 1), -1 << 20},
+	}
+
+	for _, test := range tests {
+		b, set := testBitmap(test.ids)
+		before := bitmapIDs(b)
+
+		added := b.with(test.id)
+		set[test.id] = true
+		if got := bitmapIDs(added); !reflect.DeepEqual(got, setIDs(set)) {
+			t.Errorf("%s: with: got %d ids, want %d", test.name, len(got), len(set))
+		}
+
+		removed := added.without(test.id)
+		delete(set, test.id)
+		if got := bitmapIDs(removed); !reflect.DeepEqual(got, setIDs(set)) {
+			t.Errorf("%s: without: got %d ids, want %d", test.name, len(got), len(set))
+		}
+
+		if got := bitmapIDs(b); !reflect.DeepEqual(got, before) {
+			t.Errorf("%s: original changed", test.name)
+		}
+	}
+
+	// a bitset shrinking back to an array
+	b, set := testBitmap(idRange(0, arrayContainerMax+1, 1))
+	b = b.without(0)
+	delete(set, 0)
+	if b.containers[0].bitset != nil || !reflect.DeepEqual(bitmapIDs(b), setIDs(set)) {
+		t.Errorf("bitset to array: got bitset %v", b.containers[0].bitset != nil)
+	}
+}
+
+func TestBitmapEachFrom(t *testing.T) {
+	ids := append(idRange(-70000, -60000, 7), idRange(0, 1<<18, 5)...)
+	for _, dense := range []bool{false, true} {
+		if dense {
+			ids = append(ids, idRange(1<<16, 1<<17, 1)...)
+		}
+		b, set := testBitmap(ids)
+		all := setIDs(set)
+
+		for _, from := range []int64{-1 << 40, -65000, 0, 63, 64, 65, 1 << 16, 1<<16 + 99, 1 << 18, 1 << 40} {
+			var want []int64
+			for _, id := range all {
+				if id >= from {
+					want = append(want, id)
+				}
+			}
+
+			var got []int64
+			b.eachFrom(from, func(id int64) bool {
+				got = append(got, id)
+				return true
+			})
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("dense %v, from %d: got %d ids, want %d", dense, from, len(got), len(want))
+			}
+		}
+
+		// stopping early
+		count := 0
+		b.each(func(int64) bool {
+			count++
+			return count < 10
+		})
+		if count != 10 {
+			t.Errorf("dense %v: stopped after %d, want 10", dense, count)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package booru
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -23,33 +24,17 @@ type Booru struct {
 	db       *sql.DB
 	index    string
 	baseline string
+	memory   *memoryIndex // nil unless LoadMemoryIndex has been called
 }
 
 func New(db *sql.DB, index, baseline string) *Booru {
-	return &Booru{db, index, baseline}
+	return &Booru{db: db, index: index, baseline: baseline}
 }
 
-var createStatements = []string{StatementCreatePosts, StatementCreateTags, StatementCreateRelations}
-
-// Initialize the database with all the tables used by the booru.
+// Initialize the database with all the tables used by the booru, upgrading
+// it if it was created by an older booru.
 func (b *Booru) InitDB() (err error) {
-	var transaction *sql.Tx
-	transaction, err = b.db.Begin()
-	if err != nil {
-		return
-	}
-
-	for _, create := range createStatements {
-		_, err = transaction.Exec(create)
-		if err != nil {
-			transaction.Rollback()
-			return
-		}
-	}
-
-	transaction.Commit()
-
-	return
+	return b.Migrate(context.Background())
 }
 
 func (b *Booru) Close() error {
@@ -0,0 +1,86 @@
+package booru
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// a booru in a temporary directory
+func testBooru(tb testing.TB) *Booru {
+	tb.Helper()
+
+	dir := tb.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "booru.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+
+	b := New(db, filepath.Join(dir, "index"), filepath.Join(dir, "baseline"))
+	if err = os.Mkdir(b.index, 0755); err != nil {
+		tb.Fatal(err)
+	}
+	if err = b.Migrate(context.Background()); err != nil {
+		tb.Fatal(err)
+	}
+
+	return b
+}
+
+// add count more posts without files behind them, tagged by tags(i); some
+// share a time, so that ties are broken by path
+func seedPosts(tb testing.TB, b *Booru, count int, tags func(i int) []string) (ids []int64) {
+	tb.Helper()
+	ctx := context.Background()
+
+	transaction, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer transaction.Rollback()
+
+	var seeded int64
+	if err = transaction.QueryRowContext(ctx, StatementCountPosts).Scan(&seeded); err != nil {
+		tb.Fatal(err)
+	}
+
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < count; i++ {
+		timestamp := base.Add(time.Duration(i/3) * time.Hour)
+		path := fmt.Sprintf("/posts/%d/%04d.png", seeded, (i*7919)%count)
+
+		var id int64
+		if id, err = b.addPost(ctx, transaction, path, timestamp, Metadata{}); err != nil {
+			tb.Fatal(err)
+		}
+		for _, tag := range tags(i) {
+			if _, err = b.tagPost(ctx, transaction, id, tag); err != nil {
+				tb.Fatal(err)
+			}
+		}
+		ids = append(ids, id)
+	}
+
+	if err = transaction.Commit(); err != nil {
+		tb.Fatal(err)
+	}
+	if err = b.clearIndexes(ctx); err != nil {
+		tb.Fatal(err)
+	}
+
+	return
+}
+
+func postIDs(posts []Post) (ids []int64) {
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+)
+
+var errorArchiveUsage = errors.New("expected at most one archive file")
+
+// write the library as an archive to a file, or stdout
+func exportCommand(ctx context.Context, args []string) (err error) {
+	if len(args) > 1 {
+		return errorArchiveUsage
+	}
+
+	var w io.Writer = os.Stdout
+	if len(args) == 1 {
+		var file *os.File
+		if file, err = os.Create(args[0]); err != nil {
+			return
+		}
+		defer file.Close()
+		w = file
+	}
+
+	return bru.Export(ctx, w)
+}
+
+// merge an archive from a file, or stdin, into the library
+func importCommand(ctx context.Context, args []string) (err error) {
+	if len(args) > 1 {
+		return errorArchiveUsage
+	}
+
+	var r io.Reader = os.Stdin
+	if len(args) == 1 {
+		var file *os.File
+		if file, err = os.Open(args[0]); err != nil {
+			return
+		}
+		defer file.Close()
+		r = file
+	}
+
+	return bru.Import(ctx, r)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// list every group of posts with identical contents
+func dupesCommand(ctx context.Context, args []string) (err error) {
+	groups, err := bru.DuplicateGroups(ctx)
+	if err != nil {
+		return
+	}
+
+	for _, group := range groups {
+		fmt.Printf("%s\n", group[0].Hash)
+		for _, post := range group {
+			fmt.Printf("\t%d\t%s\n", post.ID, post.Post)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/dhlk/booru"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	bru *booru.Booru
+
+	dbpath   = flag.String("db", "booru.db", "sqlite3 database")
+	index    = flag.String("index", "index", "index directory")
+	baseline = flag.String("baseline", "baseline", "baseline directory")
+)
+
+// a subcommand receives the arguments following its name
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"dupes":    {"dupes", dupesCommand},
+	"export":   {"export [archive]", exportCommand},
+	"import":   {"import [archive]", importCommand},
+	"refresh":  {"refresh [-all]", refreshCommand},
+	"reserved": {"reserved", reservedCommand},
+	"rules":    {"rules list | add pattern tag... | remove id | apply [-n]", rulesCommand},
+	"scan":     {"scan [-batch n] [-duplicates policy] [-progress n] root...", scanCommand},
+	"sidecars": {"sidecars [-format txt|json|xmp]", sidecarsCommand},
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] command [args]\n\ncommands:\n", os.Args[0])
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\n", commands[name].usage)
+	}
+
+	fmt.Fprintf(flag.CommandLine.Output(), "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("sqlite3", *dbpath)
+	if err != nil {
+		panic(err)
+	}
+
+	ctx := context.Background()
+
+	bru = booru.New(db, *index, *baseline)
+	defer bru.Close()
+	if err = bru.Migrate(ctx); err != nil {
+		panic(err)
+	}
+
+	if err = cmd.run(ctx, flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", flag.Arg(0), err)
+		bru.Close()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+)
+
+// fill in the metadata of posts added before it was recorded
+func refreshCommand(ctx context.Context, args []string) (err error) {
+	flags := flag.NewFlagSet("refresh", flag.ExitOnError)
+	all := flags.Bool("all", false, "read every post, not only those missing metadata")
+	flags.Parse(args)
+
+	refreshed, err := bru.RefreshMetadata(ctx, *all)
+	fmt.Printf("%d posts refreshed\n", refreshed)
+
+	return
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dhlk/booru"
+)
+
+// list the tags in reserved namespaces, with the query which finds them
+func reservedCommand(ctx context.Context, args []string) (err error) {
+	tags, err := bru.ReservedTags(ctx)
+	if err != nil {
+		return
+	}
+
+	for _, tag := range tags {
+		fmt.Printf("%s\t%s\n", tag, booru.TagQuery(tag))
+	}
+
+	return
+}
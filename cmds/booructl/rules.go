@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var errorRulesUsage = errors.New("usage: rules list | add pattern tag... | remove id | apply [-n]")
+
+// manage the path tagging rules
+func rulesCommand(ctx context.Context, args []string) (err error) {
+	if len(args) == 0 {
+		return errorRulesUsage
+	}
+
+	switch args[0] {
+	case "list":
+		rules, err := bru.Rules(ctx)
+		if err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			fmt.Printf("%d\t%s\t%s\n", rule.ID, rule.Pattern, strings.Join(rule.Tags, " "))
+		}
+	case "add":
+		if len(args) < 3 {
+			return errorRulesUsage
+		}
+		id, err := bru.AddRule(ctx, args[1], args[2:]...)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d\n", id)
+	case "remove":
+		if len(args) != 2 {
+			return errorRulesUsage
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		return bru.RemoveRule(ctx, id)
+	case "apply":
+		flags := flag.NewFlagSet("rules apply", flag.ExitOnError)
+		dryRun := flags.Bool("n", false, "only show the tags that would be added")
+		flags.Parse(args[1:])
+
+		changes, err := bru.ReapplyRules(ctx, *dryRun)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			for _, tag := range change.Add {
+				fmt.Printf("+%s\t%s\n", tag, change.Post.Post)
+			}
+		}
+	default:
+		return errorRulesUsage
+	}
+
+	return
+}
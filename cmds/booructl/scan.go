@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dhlk/booru"
+)
+
+var policies = map[string]booru.DuplicatePolicy{
+	"reject":   booru.DuplicateReject,
+	"merge":    booru.DuplicateMerge,
+	"location": booru.DuplicateLocation,
+}
+
+// import new files below the given roots as posts
+func scanCommand(ctx context.Context, args []string) (err error) {
+	flags := flag.NewFlagSet("scan", flag.ExitOnError)
+	batch := flags.Int("batch", booru.DefaultScanBatchSize, "posts added per transaction")
+	policy := flags.String("duplicates", "reject", "duplicate contents policy: reject, merge or location")
+	every := flags.Int64("progress", 1000, "report progress every n files")
+	flags.Parse(args)
+
+	options := booru.ScanOptions{BatchSize: *batch}
+
+	var ok bool
+	if options.Policy, ok = policies[*policy]; !ok {
+		return errors.New("unknown duplicate policy " + *policy)
+	}
+
+	if *every > 0 {
+		options.Progress = func(progress booru.ScanProgress) {
+			if progress.Scanned%*every == 0 {
+				fmt.Fprintf(os.Stderr, "%d scanned, %d added, %d merged, %d refreshed, %d skipped\n", progress.Scanned, progress.Added, progress.Merged, progress.Refreshed, progress.Skipped)
+			}
+		}
+	}
+
+	report, err := bru.Scan(ctx, flags.Args(), options)
+	for _, sidecarErr := range report.Errors {
+		fmt.Fprintf(os.Stderr, "sidecar: %v\n", sidecarErr)
+	}
+	fmt.Fprintf(os.Stderr, "%d scanned, %d added, %d merged, %d refreshed, %d skipped\n", report.Scanned, report.Added, report.Merged, report.Refreshed, report.Skipped)
+	if err != nil {
+		return
+	}
+
+	for _, post := range report.Missing {
+		fmt.Printf("missing\t%d\t%s\n", post.ID, post.Post)
+	}
+
+	return
+}
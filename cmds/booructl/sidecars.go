@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/dhlk/booru"
+)
+
+var sidecarFormats = map[string]booru.SidecarFormat{
+	"txt":  booru.SidecarText,
+	"json": booru.SidecarJSON,
+	"xmp":  booru.SidecarXMP,
+}
+
+// write every post's tags to a sidecar next to it
+func sidecarsCommand(ctx context.Context, args []string) (err error) {
+	flags := flag.NewFlagSet("sidecars", flag.ExitOnError)
+	format := flags.String("format", "txt", "sidecar format: txt, json or xmp")
+	flags.Parse(args)
+
+	sidecarFormat, ok := sidecarFormats[*format]
+	if !ok {
+		return errors.New("unknown sidecar format " + *format)
+	}
+
+	written, err := bru.ExportSidecars(ctx, sidecarFormat)
+	fmt.Printf("%d sidecars written\n", written)
+
+	return
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"errors"
 	"net/http"
+	"strings"
+
+	"github.com/dhlk/booru/parse"
 )
 
 type ErrorPage struct {
 	Base  BasePage
 	Error error
+	Query string // the line of a query which failed to parse
+	Caret string // spaces, then a caret under the problem in Query
 }
 
 func errorHandler(w http.ResponseWriter, req *http.Request, err error) {
@@ -15,5 +21,16 @@ func errorHandler(w http.ResponseWriter, req *http.Request, err error) {
 		Error: err,
 	}
 
+	var parseErr *parse.Error
+	if errors.As(err, &parseErr) {
+		ep.Query, ep.Caret = caretLine(parseErr)
+	}
+
 	templates.ExecuteTemplate(w, "error.tmpl", ep)
 }
+
+// the line of a query a parse error is on, and a caret under its column
+func caretLine(err *parse.Error) (line, caret string) {
+	lines := strings.Split(err.Query, "\n")
+	return lines[err.Position.Line-1], strings.Repeat(" ", err.Position.Column-1) + "^"
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dhlk/booru/parse"
+)
+
+func TestCaretLine(t *testing.T) {
+	tests := []struct {
+		query, line, caret string
+	}{
+		{"((", "((", "  ^"},
+		{"a ))", "a ))", "  ^"},
+		{"a\nb ((\nc", "c", " ^"},
+		{"a (( b ))\n))", "))", "^"},
+		{"é ))", "é ))", "  ^"},
+	}
+
+	for _, test := range tests {
+		_, err := parse.Parse(test.query)
+		var parseErr *parse.Error
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("%q: got %v, want a parse error", test.query, err)
+		}
+
+		line, caret := caretLine(parseErr)
+		if line != test.line || caret != test.caret {
+			t.Errorf("%q: got\n%s\n%s\nwant\n%s\n%s", test.query, line, caret, test.line, test.caret)
+		}
+	}
+}
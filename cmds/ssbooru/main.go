@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"embed"
 	"flag"
@@ -27,13 +28,14 @@ var (
 	dbpath   = flag.String("db", "booru.db", "sqlite3 database")
 	index    = flag.String("index", "index", "index directory")
 	baseline = flag.String("baseline", "baseline", "baseline directory")
+	memory   = flag.Bool("memory", false, "keep the tag indexes in memory")
 )
 
 func main() {
 	flag.Parse()
 
 	var err error
-	templates, err = template.ParseFS(templatesFS, "*.tmpl")
+	templates, err = template.New("").Funcs(template.FuncMap{"tagQuery": booru.TagQuery}).ParseFS(templatesFS, "*.tmpl")
 	if err != nil {
 		panic(err)
 	}
@@ -45,9 +47,18 @@ func main() {
 	}
 
 	bru = booru.New(db, *index, *baseline)
+	if err = bru.Migrate(context.Background()); err != nil {
+		panic(err)
+	}
+	if *memory {
+		if err = bru.LoadMemoryIndex(context.Background()); err != nil {
+			panic(err)
+		}
+	}
 
 	http.Handle("/post/", http.StripPrefix("/post/", http.HandlerFunc(postHandler)))
 	http.Handle("/resource/", http.StripPrefix("/resource/", http.HandlerFunc(resourceHandler)))
+	http.Handle("/thumb/", http.StripPrefix("/thumb/", http.HandlerFunc(thumbHandler)))
 	http.Handle("/styles/", http.StripPrefix("/styles/", http.FileServer(http.FS(stylesFS))))
 	http.HandleFunc("/index", indexHandler)
 	http.HandleFunc("/search", searchHandler)
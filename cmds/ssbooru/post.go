@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"sort"
 
 	"github.com/dhlk/booru"
 )
@@ -12,9 +14,10 @@ var (
 )
 
 type PostPage struct {
-	Base BasePage
-	Post booru.Post
-	Edit bool
+	Base    BasePage
+	Post    booru.Post
+	Implied []string
+	Edit    bool
 }
 
 func postHandler(w http.ResponseWriter, req *http.Request) {
@@ -35,9 +38,35 @@ func postHandler(w http.ResponseWriter, req *http.Request) {
 	}
 
 	post := PostPage{
-		Base: NewBasePage(),
-		Post: p,
+		Base:    NewBasePage(),
+		Post:    p,
+		Implied: impliedTags(req.Context(), p.Tags),
 	}
 
 	templates.ExecuteTemplate(w, "post.tmpl", post)
 }
+
+// tags implied by, but not attached to, a post
+func impliedTags(ctx context.Context, tags booru.Tags) (implied []string) {
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		seen[tag.Tag] = true
+	}
+
+	for _, tag := range tags {
+		closure, err := bru.ImplicationClosure(ctx, tag.Tag)
+		if err != nil {
+			continue
+		}
+		for _, t := range closure {
+			if !seen[t] {
+				seen[t] = true
+				implied = append(implied, t)
+			}
+		}
+	}
+
+	sort.Strings(implied)
+
+	return
+}
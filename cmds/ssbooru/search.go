@@ -2,7 +2,10 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"math/rand"
 	"net/http"
+	"regexp"
 	"strconv"
 
 	"github.com/dhlk/booru"
@@ -12,17 +15,33 @@ var (
 	errorBadQuery = errors.New("Bad query.")
 )
 
+var unseededRandomOrder = regexp.MustCompile(`(^|\s)order:random(\s|$)`)
+
+// the most cursors carried in back; older pages are dropped, and reached
+// again from the first page
+const maxBack = 16
+
+type ExplainPage struct {
+	Base  BasePage
+	Query string
+	Plan  *booru.Step
+}
+
+// Pages are fetched by cursor; the cursors of the last few pages before are
+// carried along in back, so that there is a way back to them.
 type SearchPage struct {
-	Base   BasePage
-	M3u    bool
-	Direct bool
-	Query  string
-	Page   int64
-	Prev   int64
-	Next   int64
-	Length int64
-	Posts  []booru.Post
-	Tags   []booru.Tag
+	Base     BasePage
+	M3u      bool
+	Direct   bool
+	Query    string
+	HasPrev  bool
+	Prev     string
+	PrevBack []string
+	Next     string
+	NextBack []string
+	Length   int64
+	Posts    []booru.Post
+	Tags     []booru.Tag
 }
 
 func searchHandler(w http.ResponseWriter, req *http.Request) {
@@ -30,30 +49,28 @@ func searchHandler(w http.ResponseWriter, req *http.Request) {
 
 	var err error
 	query := ""
-	page := int64(0)
+	cursor := ""
+	back := req.Form["back"]
 	length := int64(24)
 
 	isM3u := req.Form["m3u"] != nil
 	direct := req.Form["direct"] != nil
+	explain := req.Form["explain"] != nil
 
 	if req.Form["query"] != nil {
 		if len(req.Form["query"]) != 1 {
 			errorHandler(w, req, errorBadQuery)
 			return
 		}
-		query = req.Form["query"][0]
+		query = seedOrder(req.Form["query"][0])
 	}
 
-	if req.Form["page"] != nil {
-		if len(req.Form["page"]) != 1 {
+	if req.Form["cursor"] != nil {
+		if len(req.Form["cursor"]) != 1 {
 			errorHandler(w, req, errorBadQuery)
 			return
 		}
-		page, err = strconv.ParseInt(req.Form["page"][0], 10, 64)
-		if err != nil {
-			errorHandler(w, req, err)
-			return
-		}
+		cursor = req.Form["cursor"][0]
 	}
 
 	if req.Form["length"] != nil {
@@ -68,29 +85,58 @@ func searchHandler(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	posts, err := bru.Query(req.Context(), query, page, length)
+	if explain {
+		plan, err := bru.Explain(req.Context(), query)
+		if err != nil {
+			errorHandler(w, req, err)
+			return
+		}
+
+		templates.ExecuteTemplate(w, "explain.tmpl", ExplainPage{
+			Base:  NewBasePage(),
+			Query: query,
+			Plan:  plan,
+		})
+		return
+	}
+
+	posts, next, err := bru.Query(req.Context(), query, cursor, length)
 	if err != nil {
 		errorHandler(w, req, err)
 		return
 	}
 
-	next := page + 1
-	if length != int64(len(posts)) {
-		next = -1
+	nextBack := append(append([]string{}, back...), cursor)
+	if len(nextBack) > maxBack {
+		nextBack = nextBack[len(nextBack)-maxBack:]
 	}
 
 	search := SearchPage{
-		Base:   NewBasePage(),
-		M3u:    isM3u,
-		Direct: direct,
-		Query:  query,
-		Page:   page,
-		Prev:   page - 1,
-		Next:   next,
-		Length: length,
-		Posts:  posts,
-		Tags:   []booru.Tag{},
+		Base:     NewBasePage(),
+		M3u:      isM3u,
+		Direct:   direct,
+		Query:    query,
+		Next:     next,
+		NextBack: nextBack,
+		Length:   length,
+		Posts:    posts,
+		Tags:     []booru.Tag{},
+	}
+	if len(back) > 0 {
+		search.HasPrev = true
+		search.Prev = back[len(back)-1]
+		search.PrevBack = back[:len(back)-1]
+	} else if cursor != "" {
+		// the trail was cut short; go back to the start
+		search.HasPrev = true
 	}
 
 	templates.ExecuteTemplate(w, "search.tmpl", search)
 }
+
+// pin an unseeded random order to a seed, so that every page (and the m3u
+// playlist) linked from the results sees the same shuffle
+func seedOrder(query string) string {
+	seed := fmt.Sprintf("${1}order:random:%d${2}", rand.Int63())
+	return unseededRandomOrder.ReplaceAllString(query, seed)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/dhlk/booru"
+)
+
+// thumbnails are loaded by <img> tags, so a failure is a bare status rather
+// than the error page, and the browser shows its broken image
+func thumbHandler(w http.ResponseWriter, req *http.Request) {
+	path, err := bru.Thumbnail(req.Context(), req.URL.Path)
+	if errors.Is(err, booru.ErrorNoThumbnail) || errors.Is(err, sql.ErrNoRows) || errors.Is(err, os.ErrNotExist) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.ServeFile(w, req, path)
+}
@@ -3,28 +3,90 @@ package booru
 import (
 	"context"
 	"math/rand"
+	"sort"
 )
 
-func drainTo(ctx context.Context, source <-chan Post, sink chan<- Post) {
-	for post := range source {
+// copy source to sink, returning the error source ended with (or nil if ctx
+// is done first)
+func drainTo(ctx context.Context, source Stream, sink chan<- Post) error {
+	for post := range source.C {
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 		case sink <- post:
 		}
 	}
+	return source.Err()
+}
+
+type CancelableStream func(context.Context) Stream
+
+// A Stream is the channel of posts a CancelableStream produces, and the error
+// it ended with, if any. Err may only be called once C is closed; a stream
+// whose context is canceled ends without an error.
+type Stream struct {
+	C   <-chan Post
+	err *error
+}
+
+func (s Stream) Err() error {
+	if s.err == nil {
+		return nil
+	}
+	return *s.err
 }
 
-type CancelableStream func(context.Context) <-chan Post
+var nothing CancelableStream = func(context.Context) Stream {
+	c := make(chan Post)
+	close(c)
+	return Stream{C: c}
+}
+
+// A stream of no posts ending with err.
+func Failed(err error) CancelableStream {
+	return func(context.Context) Stream {
+		c := make(chan Post)
+		close(c)
+		return Stream{c, &err}
+	}
+}
+
+// end a stream opened with ctx with err, unless ctx is done
+func failedStream(ctx context.Context, err error) Stream {
+	if ctx.Err() != nil {
+		return nothing(ctx)
+	}
+	return Failed(err)(ctx)
+}
+
+// stream posts which are already in order
+func sliceStream(ctx context.Context, posts []Post) Stream {
+	result := make(chan Post)
+
+	go func(out chan<- Post) {
+		defer close(result)
+
+		for _, post := range posts {
+			select {
+			case <-ctx.Done():
+				return
+			case out <- post:
+			}
+		}
+	}(result)
+
+	return Stream{C: result}
+}
 
 func Skip(in CancelableStream, count int64) CancelableStream {
-	return func(ctx context.Context) <-chan Post {
+	return func(ctx context.Context) Stream {
 		return skip(ctx, in, count)
 	}
 }
 
-func skip(ctx context.Context, in CancelableStream, count int64) <-chan Post {
+func skip(ctx context.Context, in CancelableStream, count int64) Stream {
 	result := make(chan Post)
+	var err error
 
 	go func(out chan<- Post) {
 		defer close(result)
@@ -35,7 +97,7 @@ func skip(ctx context.Context, in CancelableStream, count int64) <-chan Post {
 		input := in(fwdCtx)
 
 		read := int64(0)
-		for post := range input {
+		for post := range input.C {
 			read++
 			if read > count {
 				select {
@@ -47,20 +109,21 @@ func skip(ctx context.Context, in CancelableStream, count int64) <-chan Post {
 			}
 		}
 
-		drainTo(ctx, input, result)
+		err = drainTo(ctx, input, result)
 	}(result)
 
-	return result
+	return Stream{result, &err}
 }
 
 func Limit(in CancelableStream, count int64) CancelableStream {
-	return func(ctx context.Context) <-chan Post {
+	return func(ctx context.Context) Stream {
 		return limit(ctx, in, count)
 	}
 }
 
-func limit(ctx context.Context, in CancelableStream, count int64) <-chan Post {
+func limit(ctx context.Context, in CancelableStream, count int64) Stream {
 	result := make(chan Post)
+	var err error
 
 	go func(out chan<- Post) {
 		defer close(result)
@@ -68,8 +131,10 @@ func limit(ctx context.Context, in CancelableStream, count int64) <-chan Post {
 		fwdCtx, cancel := context.WithCancel(ctx)
 		defer cancel()
 
+		input := in(fwdCtx)
+
 		wrote := int64(0)
-		for post := range in(fwdCtx) {
+		for post := range input.C {
 			wrote++
 			if wrote > count {
 				return
@@ -80,19 +145,58 @@ func limit(ctx context.Context, in CancelableStream, count int64) <-chan Post {
 			case result <- post:
 			}
 		}
+
+		err = input.Err()
 	}(result)
 
-	return result
+	return Stream{result, &err}
+}
+
+// Keep the posts of a stream for which keep is true.
+func Filter(in CancelableStream, keep func(Post) bool) CancelableStream {
+	return func(ctx context.Context) Stream {
+		return filter(ctx, in, keep)
+	}
+}
+
+func filter(ctx context.Context, in CancelableStream, keep func(Post) bool) Stream {
+	result := make(chan Post)
+	var err error
+
+	go func(out chan<- Post) {
+		defer close(result)
+
+		fwdCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		input := in(fwdCtx)
+
+		for post := range input.C {
+			if !keep(post) {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- post:
+			}
+		}
+
+		err = input.Err()
+	}(result)
+
+	return Stream{result, &err}
 }
 
 func Random(in CancelableStream, rate float64) CancelableStream {
-	return func(ctx context.Context) <-chan Post {
+	return func(ctx context.Context) Stream {
 		return random(ctx, in, rate)
 	}
 }
 
-func random(ctx context.Context, in CancelableStream, rate float64) <-chan Post {
+func random(ctx context.Context, in CancelableStream, rate float64) Stream {
 	result := make(chan Post)
+	var err error
 
 	go func(out chan<- Post) {
 		defer close(result)
@@ -100,7 +204,9 @@ func random(ctx context.Context, in CancelableStream, rate float64) <-chan Post
 		fwdCtx, cancel := context.WithCancel(ctx)
 		defer cancel()
 
-		for post := range in(fwdCtx) {
+		input := in(fwdCtx)
+
+		for post := range input.C {
 			if rand.Float64() < rate {
 				select {
 				case <-ctx.Done():
@@ -115,18 +221,57 @@ func random(ctx context.Context, in CancelableStream, rate float64) <-chan Post
 				}
 			}
 		}
+
+		err = input.Err()
 	}(result)
 
-	return result
+	return Stream{result, &err}
+}
+
+// Collect a stream and emit it again in another order.
+func Sorted(in CancelableStream, compare PostCompare) CancelableStream {
+	return func(ctx context.Context) Stream {
+		return sorted(ctx, in, compare)
+	}
+}
+
+func sorted(ctx context.Context, in CancelableStream, compare PostCompare) Stream {
+	result := make(chan Post)
+	var err error
+
+	go func(out chan<- Post) {
+		defer close(result)
+
+		fwdCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		input := in(fwdCtx)
+
+		var posts []Post
+		for post := range input.C {
+			posts = append(posts, post)
+		}
+		if err = input.Err(); err != nil {
+			return
+		}
+
+		sort.SliceStable(posts, func(i, j int) bool {
+			return compare(posts[i], posts[j]) == -1
+		})
+
+		err = drainTo(ctx, sliceStream(fwdCtx, posts), out)
+	}(result)
+
+	return Stream{result, &err}
 }
 
 func Intersection(in []CancelableStream, compare PostCompare) CancelableStream {
-	return func(ctx context.Context) <-chan Post {
+	return func(ctx context.Context) Stream {
 		return intersection(ctx, in, compare)
 	}
 }
 
-func intersection(ctx context.Context, in []CancelableStream, compare PostCompare) <-chan Post {
+func intersection(ctx context.Context, in []CancelableStream, compare PostCompare) Stream {
 	if len(in) == 0 {
 		return nothing(ctx)
 	} else if len(in) == 1 {
@@ -134,6 +279,7 @@ func intersection(ctx context.Context, in []CancelableStream, compare PostCompar
 	}
 
 	result := make(chan Post)
+	var err error
 
 	go func(out chan<- Post) {
 		defer close(result)
@@ -141,7 +287,8 @@ func intersection(ctx context.Context, in []CancelableStream, compare PostCompar
 		fwdCtx, cancel := context.WithCancel(ctx)
 		defer cancel()
 
-		inputs := make([]<-chan Post, len(in))
+		// the first input to end ends the intersection
+		inputs := make([]Stream, len(in))
 		post := make([]Post, len(in))
 		ok := make([]bool, len(in))
 
@@ -150,8 +297,9 @@ func intersection(ctx context.Context, in []CancelableStream, compare PostCompar
 		}
 
 		for i := range inputs {
-			post[i], ok[i] = <-inputs[i]
+			post[i], ok[i] = <-inputs[i].C
 			if !ok[i] {
+				err = inputs[i].Err()
 				return
 			}
 		}
@@ -163,7 +311,11 @@ func intersection(ctx context.Context, in []CancelableStream, compare PostCompar
 					case <-ctx.Done():
 						return
 					case out <- post[0]:
-						post[0], ok[0] = <-inputs[0]
+						post[0], ok[0] = <-inputs[0].C
+						if !ok[0] {
+							err = inputs[0].Err()
+							return
+						}
 					}
 					break
 				}
@@ -172,14 +324,16 @@ func intersection(ctx context.Context, in []CancelableStream, compare PostCompar
 				if order == 0 {
 					continue
 				} else if order == -1 {
-					post[i], ok[i] = <-inputs[i]
+					post[i], ok[i] = <-inputs[i].C
 					if !ok[i] {
+						err = inputs[i].Err()
 						return
 					}
 					break
 				} else if order == 1 {
-					post[i+1], ok[i+1] = <-inputs[i+1]
+					post[i+1], ok[i+1] = <-inputs[i+1].C
 					if !ok[i+1] {
+						err = inputs[i+1].Err()
 						return
 					}
 					break
@@ -188,16 +342,16 @@ func intersection(ctx context.Context, in []CancelableStream, compare PostCompar
 		}
 	}(result)
 
-	return result
+	return Stream{result, &err}
 }
 
 func Union(in []CancelableStream, compare PostCompare) CancelableStream {
-	return func(ctx context.Context) <-chan Post {
+	return func(ctx context.Context) Stream {
 		return union(ctx, in, compare)
 	}
 }
 
-func union(ctx context.Context, in []CancelableStream, compare PostCompare) <-chan Post {
+func union(ctx context.Context, in []CancelableStream, compare PostCompare) Stream {
 	if len(in) == 0 {
 		return nothing(ctx)
 	} else if len(in) == 1 {
@@ -205,6 +359,7 @@ func union(ctx context.Context, in []CancelableStream, compare PostCompare) <-ch
 	}
 
 	result := make(chan Post)
+	var err error
 
 	go func(out chan<- Post) {
 		defer close(result)
@@ -212,7 +367,8 @@ func union(ctx context.Context, in []CancelableStream, compare PostCompare) <-ch
 		fwdCtx, cancel := context.WithCancel(ctx)
 		defer cancel()
 
-		inputs := make([]<-chan Post, len(in))
+		// an input ending with an error ends the union
+		inputs := make([]Stream, len(in))
 		post := make([]Post, len(in))
 		ok := make([]bool, len(in))
 
@@ -220,8 +376,17 @@ func union(ctx context.Context, in []CancelableStream, compare PostCompare) <-ch
 			inputs[i] = in[i](fwdCtx)
 		}
 
+		next := func(i int) bool {
+			if post[i], ok[i] = <-inputs[i].C; !ok[i] {
+				err = inputs[i].Err()
+			}
+			return err == nil
+		}
+
 		for i := range inputs {
-			post[i], ok[i] = <-inputs[i]
+			if !next(i) {
+				return
+			}
 		}
 
 		for {
@@ -245,7 +410,9 @@ func union(ctx context.Context, in []CancelableStream, compare PostCompare) <-ch
 
 				order := compare(post[min], post[i])
 				if order == 0 {
-					post[i], ok[i] = <-inputs[i]
+					if !next(i) {
+						return
+					}
 				} else if order == -1 {
 					continue
 				} else if order == 1 {
@@ -257,23 +424,26 @@ func union(ctx context.Context, in []CancelableStream, compare PostCompare) <-ch
 			case <-ctx.Done():
 				return
 			case out <- post[min]:
-				post[min], ok[min] = <-inputs[min]
+				if !next(min) {
+					return
+				}
 			}
 		}
 	}(result)
 
-	return result
+	return Stream{result, &err}
 }
 
-// assumes in is a strict subset of space!
+// the posts of space which are not in in
 func Complement(in, space CancelableStream, compare PostCompare) CancelableStream {
-	return func(ctx context.Context) <-chan Post {
+	return func(ctx context.Context) Stream {
 		return complement(ctx, in, space, compare)
 	}
 }
 
-func complement(ctx context.Context, in, postSpace CancelableStream, compare PostCompare) <-chan Post {
+func complement(ctx context.Context, in, postSpace CancelableStream, compare PostCompare) Stream {
 	result := make(chan Post)
+	var err error
 
 	go func(out chan<- Post) {
 		defer close(result)
@@ -284,35 +454,44 @@ func complement(ctx context.Context, in, postSpace CancelableStream, compare Pos
 		input := in(fwdCtx)
 		space := postSpace(fwdCtx)
 
-		iv, iok := <-input
-		sv, sok := <-space
+		iv, iok := <-input.C
+		sv, sok := <-space.C
 
-		for iok {
+		for iok && sok {
 			order := compare(iv, sv)
 			if order == 0 {
-				iv, iok = <-input
-				sv, sok = <-space
+				iv, iok = <-input.C
+				sv, sok = <-space.C
 			} else if order == -1 {
-				iv, iok = <-input
+				iv, iok = <-input.C
 			} else if order == 1 {
 				select {
 				case <-ctx.Done():
 					return
 				case out <- sv:
-					sv, sok = <-space
+					sv, sok = <-space.C
 				}
 			}
 		}
 
-		if sok {
-			select {
-			case <-ctx.Done():
+		// a post missing from either input would change the result
+		if !iok {
+			if err = input.Err(); err != nil {
 				return
-			case out <- sv:
-				drainTo(ctx, space, out)
 			}
 		}
+		if !sok {
+			err = space.Err()
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case out <- sv:
+			err = drainTo(ctx, space, out)
+		}
 	}(result)
 
-	return result
+	return Stream{result, &err}
 }
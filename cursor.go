@@ -0,0 +1,69 @@
+package booru
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// errors
+var (
+	ErrorInvalidCursor = errors.New("invalid cursor")
+)
+
+// the sort key of the last post of a page, in the order it was sorted by
+type cursor struct {
+	Order string    `json:"order"`
+	ID    int64     `json:"id"`
+	Time  time.Time `json:"time"`
+	Post  string    `json:"post"`
+}
+
+// encode the position after a post as an opaque string
+func encodeCursor(order Order, post Post) string {
+	data, _ := json.Marshal(cursor{order.Name, post.ID, post.Time, post.Post})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decode a cursor for a query in order; an empty cursor is the start
+func decodeCursor(encoded string, order Order) (after *Post, err error) {
+	if encoded == "" {
+		return
+	}
+
+	var data []byte
+	if data, err = base64.RawURLEncoding.DecodeString(encoded); err != nil {
+		return nil, ErrorInvalidCursor
+	}
+
+	var c cursor
+	if err = json.Unmarshal(data, &c); err != nil || c.Order != order.Name {
+		return nil, ErrorInvalidCursor
+	}
+
+	return &Post{ID: c.ID, Time: c.Time, Post: c.Post}, nil
+}
+
+// whether a post is at or before the cursor of a query, and so was on an
+// earlier page
+func (options queryOptions) seen(post Post) bool {
+	return options.after != nil && options.order.Compare(post, *options.after) <= 0
+}
+
+// seen, for seeking an index past earlier pages; nil when the index can't be
+// (or needn't be) sought
+func (options queryOptions) indexSeen() func(Post) bool {
+	if options.after == nil || !options.order.indexed() {
+		return nil
+	}
+	return options.seen
+}
+
+// drop the posts of earlier pages from a stream which can't seek past them
+func (options queryOptions) unseen(in CancelableStream) CancelableStream {
+	if options.after == nil {
+		return in
+	}
+	return Filter(in, func(post Post) bool { return !options.seen(post) })
+}
@@ -0,0 +1,122 @@
+package booru
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+var cursorTestOrders = []string{"", "order:asc", "order:path", "order:id", "order:random:7"}
+
+// pages of any length, from either index, put together are the whole result
+func TestCursorOrders(t *testing.T) {
+	ctx := context.Background()
+
+	b := testBooru(t)
+	seedPosts(t, b, 30, func(i int) []string { return []string{"a", "b"}[:i%3] })
+
+	for _, memory := range []bool{false, true} {
+		if memory {
+			if err := b.LoadMemoryIndex(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, order := range cursorTestOrders {
+			query := "a " + order
+			whole := allPages(t, b, query, 100)
+			if len(whole) != 20 {
+				t.Fatalf("memory %v: %s: got %d posts, want 20", memory, query, len(whole))
+			}
+
+			for _, length := range []int64{1, 3, 7, 20} {
+				if got := allPages(t, b, query, length); !reflect.DeepEqual(got, whole) {
+					t.Errorf("memory %v: %s in pages of %d: got %v, want %v", memory, query, length, got, whole)
+				}
+			}
+		}
+	}
+}
+
+// a post added between pages is on a later page if it sorts after the
+// cursor, and on none otherwise; no post is shown twice or skipped
+func TestCursorInsert(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		order Order
+		rest  int // posts on the pages after the first
+	}{
+		{OrderDescending, 9},
+		{OrderAscending, 7},
+	}
+
+	for _, test := range tests {
+		b := testBooru(t)
+		seedPosts(t, b, 12, func(int) []string { return []string{"a"} })
+
+		query := "a order:" + test.order.Name
+		first, cursor, err := b.Query(ctx, query, "", 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the oldest posts, sorting last in descending order and first in ascending
+		seedPosts(t, b, 2, func(int) []string { return []string{"a"} })
+
+		var rest []int64
+		for cursor != "" {
+			var posts []Post
+			if posts, cursor, err = b.Query(ctx, query, cursor, 5); err != nil {
+				t.Fatal(err)
+			}
+			rest = append(rest, postIDs(posts)...)
+		}
+
+		whole, _, err := b.Query(ctx, query, "", 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []int64
+		for _, post := range whole {
+			if test.order.Compare(post, first[len(first)-1]) > 0 {
+				want = append(want, post.ID)
+			}
+		}
+
+		if !reflect.DeepEqual(rest, want) {
+			t.Errorf("%s: got %v after the first page, want %v", query, rest, want)
+		}
+		if len(rest) != test.rest {
+			t.Errorf("%s: got %d posts after the first page, want %d", query, len(rest), test.rest)
+		}
+	}
+}
+
+// a cursor only continues a query in the order it was made in
+func TestCursorOrderMismatch(t *testing.T) {
+	ctx := context.Background()
+
+	b := testBooru(t)
+	seedPosts(t, b, 10, func(int) []string { return []string{"a"} })
+
+	for _, from := range cursorTestOrders {
+		_, cursor, err := b.Query(ctx, "a "+from, "", 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, to := range cursorTestOrders {
+			_, _, err = b.Query(ctx, "a "+to, cursor, 3)
+			if from == to && err != nil {
+				t.Errorf("%q cursor in %q: %v", from, to, err)
+			} else if from != to && err != ErrorInvalidCursor {
+				t.Errorf("%q cursor in %q: got %v, want %v", from, to, err, ErrorInvalidCursor)
+			}
+		}
+	}
+
+	if _, _, err := b.Query(ctx, "a", "not a cursor", 3); err != ErrorInvalidCursor {
+		t.Errorf("garbage cursor: got %v, want %v", err, ErrorInvalidCursor)
+	}
+}
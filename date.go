@@ -0,0 +1,206 @@
+package booru
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errors
+var (
+	ErrorInvalidDate = errors.New("invalid date")
+	ErrorInvalidAge  = errors.New("invalid age")
+)
+
+// the granularities a date may be written in
+var dateLayouts = []struct {
+	layout string
+	next   func(time.Time) time.Time
+}{
+	{"2006-01-02T15:04:05", func(t time.Time) time.Time { return t.Add(time.Second) }},
+	{"2006-01-02T15:04", func(t time.Time) time.Time { return t.Add(time.Minute) }},
+	{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+	{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+	{"2006", func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+}
+
+var ageUnits = map[byte]time.Duration{
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+	'y': 365 * 24 * time.Hour,
+}
+
+// posts with from <= Time < to; a zero bound is unbounded
+type timeRange struct {
+	from, to time.Time
+}
+
+func (r timeRange) contains(t time.Time) bool {
+	return (r.from.IsZero() || !t.Before(r.from)) && (r.to.IsZero() || t.Before(r.to))
+}
+
+// the period a date covers, in local time
+func parseDate(date string) (start, end time.Time, err error) {
+	for _, layout := range dateLayouts {
+		if start, err = time.ParseInLocation(layout.layout, date, time.Local); err == nil {
+			return start, layout.next(start), nil
+		}
+	}
+	err = ErrorInvalidDate
+	return
+}
+
+// Parse the argument of a date: word:
+//
+//	2021-06-01          the day (or month, year, minute, second) written
+//	>2021-06, >=2021-06  after, or from the start of, the month
+//	<2021-06, <=2021-06  before, or up to the end of, the month
+//	2021-01-01..2021-03  from the start of one period to the end of another;
+//	                     either may be left out
+func parseDateRange(arg string) (r timeRange, err error) {
+	if from, to, ok := strings.Cut(arg, ".."); ok {
+		if from != "" {
+			if r.from, _, err = parseDate(from); err != nil {
+				return
+			}
+		}
+		if to != "" {
+			if _, r.to, err = parseDate(to); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	op, date := splitComparison(arg)
+
+	var start, end time.Time
+	if start, end, err = parseDate(date); err != nil {
+		return
+	}
+
+	switch op {
+	case "":
+		r.from, r.to = start, end
+	case ">":
+		r.from = end
+	case ">=":
+		r.from = start
+	case "<":
+		r.to = start
+	case "<=":
+		r.to = end
+	}
+
+	return
+}
+
+// Parse the argument of an age: word, relative to now: <7d is newer than a
+// week, >1y older than a year. Units are h, d, w and y; without a comparison
+// the age is a maximum.
+func parseAgeRange(arg string, now time.Time) (r timeRange, err error) {
+	op, age := splitComparison(arg)
+	if len(age) < 2 {
+		err = ErrorInvalidAge
+		return
+	}
+
+	unit, ok := ageUnits[age[len(age)-1]]
+	if !ok {
+		err = ErrorInvalidAge
+		return
+	}
+
+	var count int64
+	if count, err = strconv.ParseInt(age[:len(age)-1], 10, 64); err != nil {
+		err = ErrorInvalidAge
+		return
+	}
+	bound := now.Add(-time.Duration(count) * unit)
+
+	switch op {
+	case "", "<", "<=":
+		r.from = bound
+	case ">", ">=":
+		r.to = bound
+	}
+
+	return
+}
+
+func splitComparison(arg string) (op, rest string) {
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		if strings.HasPrefix(arg, op) {
+			return op, arg[len(op):]
+		}
+	}
+	return "", arg
+}
+
+// stream the posts in a time range; the global index is in descending time
+// order, so it is searched for the end of the range (or, if later, the first
+// post not yet seen) and read until the start
+func (b *Booru) timeRangeStream(r timeRange, seen func(Post) bool) CancelableStream {
+	return func(ctx context.Context) Stream {
+		result := make(chan Post)
+		var err error
+
+		go func(out chan<- Post) {
+			defer close(result)
+
+			fwdCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			var after func(Post) bool
+			if !r.to.IsZero() || seen != nil {
+				after = func(post Post) bool {
+					return (!r.to.IsZero() && !post.Time.Before(r.to)) || (seen != nil && seen(post))
+				}
+			}
+
+			input := b.indexStreamFrom(fwdCtx, globalIndexTag, after)
+			for post := range input.C {
+				if !r.contains(post.Time) {
+					if !r.from.IsZero() && post.Time.Before(r.from) {
+						return
+					}
+					continue
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case out <- post:
+				}
+			}
+
+			err = input.Err()
+		}(result)
+
+		return Stream{result, &err}
+	}
+}
+
+func (b *Booru) dateStream(arg string, options queryOptions) CancelableStream {
+	r, err := parseDateRange(arg)
+	if err != nil {
+		return Failed(fmt.Errorf("date:%s: %w", arg, err))
+	}
+
+	step, _ := options.step("Date", arg)
+	return step.wrap(b.timeRangeStream(r, options.indexSeen()))
+}
+
+func (b *Booru) ageStream(arg string, options queryOptions) CancelableStream {
+	r, err := parseAgeRange(arg, time.Now())
+	if err != nil {
+		return Failed(fmt.Errorf("age:%s: %w", arg, err))
+	}
+
+	step, _ := options.step("Age", arg)
+	return step.wrap(b.timeRangeStream(r, options.indexSeen()))
+}
@@ -0,0 +1,133 @@
+package booru
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// database statements
+const (
+	StatementCreatePostHashIndex = "create index posts_hash on posts (hash)"
+	StatementCreateLocations     = "create table locations (post integer not null, location text unique not null)"
+	StatementQueryPostByHash     = "select id from posts where hash = ? order by id limit 1"
+	StatementQueryLocation       = "select post from locations where location = ?"
+	StatementInsertLocation      = "insert into locations (post, location) values (?, ?)"
+	StatementDeletePostLocations = "delete from locations where post = ?"
+	StatementQueryDuplicates     = "select " + postColumns + " from posts where hash in (select hash from posts where hash != '' group by hash having count(*) > 1) order by hash, timestamp desc, post desc"
+)
+
+// What to do when a new post has the same contents as an existing one.
+type DuplicatePolicy int
+
+const (
+	DuplicateReject   DuplicatePolicy = iota // fail with ErrorDuplicatePost
+	DuplicateMerge                           // add the tags to the existing post
+	DuplicateLocation                        // record the path as another location of the existing post, without its tags
+)
+
+// Add a new post with tags, handling posts whose contents are already in the
+// booru according to policy: a merged duplicate adds its tags to the existing
+// post, and a located one only its path. The returned id is that of the
+// existing post when a duplicate was merged or located.
+func (b *Booru) AddPostPolicy(ctx context.Context, post string, timestamp time.Time, policy DuplicatePolicy, tags ...string) (id int64, err error) {
+	var metadata Metadata
+	if metadata, err = ReadMetadata(post); err != nil {
+		return
+	}
+
+	var transaction *sql.Tx
+	if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer transaction.Rollback()
+
+	var write memoryWrite
+	if id, write, err = b.addPostPolicy(ctx, transaction, post, timestamp, metadata, policy, tags); err != nil {
+		return
+	}
+
+	if err = transaction.Commit(); err != nil {
+		return
+	}
+
+	b.memory.apply(write)
+	err = b.invalidateIndexes(write.stale()...)
+
+	return
+}
+
+// returns the change made, for the indexes
+func (b *Booru) addPostPolicy(ctx context.Context, transaction *sql.Tx, post string, timestamp time.Time, metadata Metadata, policy DuplicatePolicy, tags []string) (id int64, write memoryWrite, err error) {
+	existing := int64(-1)
+	if metadata.Hash != "" {
+		err = transaction.QueryRowContext(ctx, StatementQueryPostByHash, metadata.Hash).Scan(&existing)
+		if err != nil && err != sql.ErrNoRows {
+			return
+		}
+	}
+
+	if existing == -1 {
+		if id, err = b.addPost(ctx, transaction, post, timestamp, metadata); err != nil {
+			return
+		}
+		write.post = &Post{ID: id, Time: timestamp, Post: post, Metadata: metadata}
+	} else {
+		id = existing
+		switch policy {
+		case DuplicateMerge:
+		case DuplicateLocation:
+			if err = b.checkPath(ctx, transaction, post); err != nil {
+				return
+			}
+			if _, err = transaction.ExecContext(ctx, StatementInsertLocation, id, post); err != nil {
+				return
+			}
+			// the existing post keeps its own tags
+			tags = nil
+		default:
+			err = ErrorDuplicatePost
+			return
+		}
+	}
+
+	for _, tag := range tags {
+		var canonical string
+		if canonical, err = b.tagPost(ctx, transaction, id, tag); err == ErrorDuplicateTag {
+			continue
+		} else if err != nil {
+			return
+		}
+		write.tagged = append(write.tagged, canonical)
+	}
+	write.id = id
+	err = nil
+
+	return
+}
+
+// Find every group of posts with identical contents.
+func (b *Booru) DuplicateGroups(ctx context.Context) (groups [][]Post, err error) {
+	var rows *sql.Rows
+	if rows, err = b.db.QueryContext(ctx, StatementQueryDuplicates); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var post Post
+		if err = rows.Scan(post.fields()...); err != nil {
+			return
+		}
+
+		if last := len(groups) - 1; last >= 0 && groups[last][0].Hash == post.Hash {
+			groups[last] = append(groups[last], post)
+		} else {
+			groups = append(groups, []Post{post})
+		}
+	}
+
+	err = rows.Err()
+
+	return
+}
@@ -0,0 +1,71 @@
+package booru
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAddPostPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		policy    DuplicatePolicy
+		err       error
+		tags      []string
+		locations []string
+	}{
+		{DuplicateReject, ErrorDuplicatePost, []string{"first"}, nil},
+		{DuplicateMerge, nil, []string{"first", "second"}, nil},
+		{DuplicateLocation, nil, []string{"first"}, []string{"copy.png"}},
+	}
+
+	for _, test := range tests {
+		b := testBooru(t)
+		dir := t.TempDir()
+		for _, name := range []string{"original.png", "copy.png"} {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte("same contents"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		id, err := b.AddPostPolicy(ctx, filepath.Join(dir, "original.png"), time.Now(), test.policy, "first")
+		if err != nil {
+			t.Fatal(err)
+		}
+		duplicate, err := b.AddPostPolicy(ctx, filepath.Join(dir, "copy.png"), time.Now(), test.policy, "second")
+		if err != test.err {
+			t.Errorf("policy %d: got error %v, want %v", test.policy, err, test.err)
+		}
+		if err == nil && duplicate != id {
+			t.Errorf("policy %d: got post %d, want the existing %d", test.policy, duplicate, id)
+		}
+
+		var tags []string
+		postTags, err := b.GetPostTags(ctx, nil, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tag := range postTags {
+			tags = append(tags, tag.Tag)
+		}
+		if !reflect.DeepEqual(tags, test.tags) {
+			t.Errorf("policy %d: got tags %v, want %v", test.policy, tags, test.tags)
+		}
+
+		locations, err := postLocations(ctx, b.db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, location := range locations[id] {
+			names = append(names, filepath.Base(location))
+		}
+		if !reflect.DeepEqual(names, test.locations) {
+			t.Errorf("policy %d: got locations %v, want %v", test.policy, names, test.locations)
+		}
+	}
+}
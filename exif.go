@@ -0,0 +1,137 @@
+package booru
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// exif tags
+const (
+	exifTagDateTime         = 0x0132
+	exifTagExifIFD          = 0x8769
+	exifTagDateTimeOriginal = 0x9003
+	exifTypeASCII           = 2
+	exifTypeLong            = 4
+)
+
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+var errorNoExif = errors.New("no exif data")
+
+// Read the time a jpeg was taken from its exif data: DateTimeOriginal, falling
+// back to DateTime. Exif times carry no zone, so they are read as local time.
+func ExifTime(path string) (t time.Time, err error) {
+	var file *os.File
+	if file, err = os.Open(path); err != nil {
+		return
+	}
+	defer file.Close()
+
+	var tiff []byte
+	if tiff, err = exifSegment(bufio.NewReader(file)); err != nil {
+		return
+	}
+
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(tiff, []byte("II*\000")):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(tiff, []byte("MM\000*")):
+		order = binary.BigEndian
+	default:
+		err = errorNoExif
+		return
+	}
+
+	ifd0 := exifIFD(tiff, order, order.Uint32(tiff[4:]))
+
+	value, ok := "", false
+	if offset, found := ifd0[exifTagExifIFD]; found && offset.typ == exifTypeLong {
+		value, ok = exifIFD(tiff, order, offset.value)[exifTagDateTimeOriginal].ascii(tiff)
+	}
+	if !ok {
+		value, ok = ifd0[exifTagDateTime].ascii(tiff)
+	}
+	if !ok {
+		err = errorNoExif
+		return
+	}
+
+	return time.ParseInLocation(exifTimeLayout, value, time.Local)
+}
+
+// find the APP1 exif segment of a jpeg, returning its tiff data
+func exifSegment(r *bufio.Reader) (tiff []byte, err error) {
+	var soi [2]byte
+	if _, err = io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return nil, errorNoExif
+	}
+
+	for {
+		var marker [4]byte
+		if _, err = io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xFF {
+			return nil, errorNoExif
+		}
+
+		// metadata segments all precede the image data
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil, errorNoExif
+		}
+
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return nil, errorNoExif
+		}
+
+		segment := make([]byte, length)
+		if _, err = io.ReadFull(r, segment); err != nil {
+			return
+		}
+
+		if marker[1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\000\000")) && len(segment) >= 14 {
+			return segment[6:], nil
+		}
+	}
+}
+
+type exifEntry struct {
+	typ   uint16
+	count uint32
+	value uint32 // the value itself if it fits, otherwise its offset
+}
+
+func (e exifEntry) ascii(tiff []byte) (string, bool) {
+	if e.typ != exifTypeASCII || e.count < uint32(len(exifTimeLayout)) || uint64(e.value)+uint64(len(exifTimeLayout)) > uint64(len(tiff)) {
+		return "", false
+	}
+	return string(tiff[e.value : e.value+uint32(len(exifTimeLayout))]), true
+}
+
+// read the entries of the image file directory at offset
+func exifIFD(tiff []byte, order binary.ByteOrder, offset uint32) map[uint16]exifEntry {
+	entries := map[uint16]exifEntry{}
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return entries
+	}
+
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		start := uint64(offset) + 2 + uint64(i)*12
+		if start+12 > uint64(len(tiff)) {
+			break
+		}
+		entry := tiff[start : start+12]
+		entries[order.Uint16(entry)] = exifEntry{
+			typ:   order.Uint16(entry[2:]),
+			count: order.Uint32(entry[4:]),
+			value: order.Uint32(entry[8:]),
+		}
+	}
+
+	return entries
+}
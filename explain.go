@@ -0,0 +1,121 @@
+package booru
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Step is one operator in the stream of a query, as run by Explain: a set
+// operation, an index scan, or the expansion of a word such as a baseline.
+type Step struct {
+	Operator string
+	Argument string        // the tag, pattern or query the operator works on
+	Read     int64         // posts taken from the steps below (or the index)
+	Emitted  int64         // posts passed on
+	Duration time.Duration // from opening the stream to closing it
+	Steps    []*Step
+
+	mutex    sync.Mutex
+	emitted  int64
+	duration int64
+}
+
+// Run a query to completion and describe how its results were streamed.
+func (b *Booru) Explain(ctx context.Context, query string) (plan *Step, err error) {
+	fwdCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	root := &Step{}
+
+	var results CancelableStream
+	if results, _, err = b.query(ctx, query, "", queryOptions{explain: root}); err != nil {
+		return
+	}
+
+	stream := results(fwdCtx)
+	for range stream.C {
+	}
+	if err = stream.Err(); err != nil {
+		return
+	}
+
+	if len(root.Steps) == 0 {
+		return
+	}
+	plan = root.Steps[0]
+	plan.finish()
+
+	return
+}
+
+// add a step below the one being built, returning the options to build what
+// it reads from with; nothing is recorded unless the query is being explained
+func (options queryOptions) step(operator, argument string) (*Step, queryOptions) {
+	if options.explain == nil {
+		return nil, options
+	}
+
+	step := &Step{Operator: operator, Argument: argument}
+
+	options.explain.mutex.Lock()
+	options.explain.Steps = append(options.explain.Steps, step)
+	options.explain.mutex.Unlock()
+
+	options.explain = step
+	return step, options
+}
+
+// count the posts passing out of a stream, and time it
+func (s *Step) wrap(in CancelableStream) CancelableStream {
+	if s == nil {
+		return in
+	}
+
+	return func(ctx context.Context) Stream {
+		result := make(chan Post)
+		var err error
+
+		go func(out chan<- Post) {
+			defer close(result)
+
+			start := time.Now()
+			defer func() { atomic.StoreInt64(&s.duration, int64(time.Since(start))) }()
+
+			fwdCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			input := in(fwdCtx)
+			for post := range input.C {
+				select {
+				case <-ctx.Done():
+					return
+				case out <- post:
+					atomic.AddInt64(&s.emitted, 1)
+				}
+			}
+
+			err = input.Err()
+		}(result)
+
+		return Stream{result, &err}
+	}
+}
+
+// fill in the counts once the query has run
+func (s *Step) finish() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.Emitted = atomic.LoadInt64(&s.emitted)
+	s.Duration = time.Duration(atomic.LoadInt64(&s.duration))
+
+	if len(s.Steps) == 0 {
+		s.Read = s.Emitted
+	}
+	for _, step := range s.Steps {
+		step.finish()
+		s.Read += step.Emitted
+	}
+}
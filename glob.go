@@ -0,0 +1,105 @@
+package booru
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Whether a query word is a wildcard pattern: * matches any run of
+// characters and ? any single character.
+func IsGlob(word string) bool {
+	return strings.ContainsAny(word, "*?")
+}
+
+// the literal text before the first wildcard
+func globPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?"); i != -1 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString(`^(?s:`)
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			expr.WriteString(`.*`)
+		case '?':
+			expr.WriteString(`.`)
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString(`)$`)
+
+	return regexp.Compile(expr.String())
+}
+
+// Find the tags matching a wildcard pattern, in sorted order. Only the part
+// of the tag index sharing the pattern's literal prefix is read.
+func (b *Booru) GlobTags(ctx context.Context, pattern string) (tags []string, err error) {
+	var regex *regexp.Regexp
+	if regex, err = globRegexp(pattern); err != nil {
+		return
+	}
+	prefix := globPrefix(pattern)
+
+	var index *os.File
+	if index, err = b.openTagIndex(ctx); err != nil {
+		return
+	}
+	defer index.Close()
+
+	var offset int64
+	if offset, err = seekTagIndex(index, prefix); err != nil {
+		return
+	}
+	if _, err = index.Seek(offset, io.SeekStart); err != nil {
+		return
+	}
+
+	decoder := json.NewDecoder(index)
+	for decoder.More() {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		var tag string
+		if err = decoder.Decode(&tag); err != nil {
+			return
+		}
+
+		if !strings.HasPrefix(tag, prefix) {
+			break
+		}
+		if regex.MatchString(tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	return
+}
+
+// stream the posts of every tag matching a wildcard pattern, in index order
+func (b *Booru) globStream(pattern string, options queryOptions) CancelableStream {
+	step, options := options.step("Glob", pattern)
+	return step.wrap(func(ctx context.Context) Stream {
+		tags, err := b.GlobTags(ctx, pattern)
+		if err != nil {
+			return failedStream(ctx, err)
+		}
+
+		streams := make([]CancelableStream, len(tags))
+		for i, tag := range tags {
+			streams[i] = b.tagStream(tag, options)
+		}
+
+		return union(ctx, streams, OrderDescending.Compare)
+	})
+}
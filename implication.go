@@ -0,0 +1,187 @@
+package booru
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+)
+
+// database statements
+const (
+	StatementCreateImplications = "create table implications (tag text not null, implies text not null, primary key (tag, implies))"
+	StatementQueryImplies       = "select implies from implications where tag = ?"
+	StatementQueryImpliedBy     = "select tag from implications where implies = ?"
+	StatementQueryImplication   = "select 1 from implications where tag = ? and implies = ?"
+	StatementQueryImplications  = "select tag, implies from implications order by tag, implies"
+	StatementInsertImplication  = "insert into implications (tag, implies) values (?, ?)"
+	StatementDeleteImplication  = "delete from implications where tag = ? and implies = ?"
+)
+
+// errors
+var (
+	ErrorDuplicateImplication = errors.New("duplicate implication")
+	ErrorImplicationCycle     = errors.New("implication cycle")
+)
+
+// Posts tagged with Tag are treated as also having Implies.
+type Implication struct {
+	Tag     string
+	Implies string
+}
+
+// follow statement from tag transitively, returning every tag reached
+// (excluding tag itself unless there is a cycle)
+func closure(ctx context.Context, db querier, statement, tag string) (tags []string, err error) {
+	seen := map[string]bool{}
+	queue := []string{tag}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		var next []string
+		if next, err = queryStrings(ctx, db, statement, current); err != nil {
+			return
+		}
+
+		for _, t := range next {
+			if seen[t] {
+				continue
+			}
+			seen[t] = true
+			tags = append(tags, t)
+			queue = append(queue, t)
+		}
+	}
+
+	sort.Strings(tags)
+
+	return
+}
+
+func queryStrings(ctx context.Context, db querier, statement string, args ...interface{}) (strs []string, err error) {
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, statement, args...); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var str string
+		if err = rows.Scan(&str); err != nil {
+			return
+		}
+		strs = append(strs, str)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+// Make tag imply another tag. Both are resolved through aliases first, and
+// implications which would form a cycle are rejected.
+func (b *Booru) AddImplication(ctx context.Context, tag, implies string) (err error) {
+	var transaction *sql.Tx
+	if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer transaction.Rollback()
+
+	if err = b.addImplication(ctx, transaction, tag, implies); err != nil {
+		return
+	}
+
+	return transaction.Commit()
+}
+
+func (b *Booru) addImplication(ctx context.Context, transaction *sql.Tx, tag, implies string) (err error) {
+	if tag, err = canonicalTag(ctx, transaction, tag); err != nil {
+		return
+	}
+	if implies, err = canonicalTag(ctx, transaction, implies); err != nil {
+		return
+	}
+
+	if tag == implies {
+		return ErrorImplicationCycle
+	}
+
+	var exists int
+	err = transaction.QueryRowContext(ctx, StatementQueryImplication, tag, implies).Scan(&exists)
+	if err == nil {
+		return ErrorDuplicateImplication
+	} else if err != sql.ErrNoRows {
+		return
+	}
+
+	var parents []string
+	if parents, err = closure(ctx, transaction, StatementQueryImplies, implies); err != nil {
+		return
+	}
+	for _, parent := range parents {
+		if parent == tag {
+			return ErrorImplicationCycle
+		}
+	}
+
+	_, err = transaction.ExecContext(ctx, StatementInsertImplication, tag, implies)
+
+	return
+}
+
+func (b *Booru) RemoveImplication(ctx context.Context, tag, implies string) (err error) {
+	var transaction *sql.Tx
+	if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer transaction.Rollback()
+
+	if tag, err = canonicalTag(ctx, transaction, tag); err != nil {
+		return
+	}
+	if implies, err = canonicalTag(ctx, transaction, implies); err != nil {
+		return
+	}
+
+	if _, err = transaction.ExecContext(ctx, StatementDeleteImplication, tag, implies); err != nil {
+		return
+	}
+
+	return transaction.Commit()
+}
+
+// Get every tag implied by tag, transitively, in sorted order.
+func (b *Booru) ImplicationClosure(ctx context.Context, tag string) (tags []string, err error) {
+	if tag, err = canonicalTag(ctx, b.db, tag); err != nil {
+		return
+	}
+
+	return closure(ctx, b.db, StatementQueryImplies, tag)
+}
+
+// Get every implication.
+func (b *Booru) Implications(ctx context.Context) (implications []Implication, err error) {
+	return queryImplications(ctx, b.db)
+}
+
+func queryImplications(ctx context.Context, db querier) (implications []Implication, err error) {
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, StatementQueryImplications); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var implication Implication
+		if err = rows.Scan(&implication.Tag, &implication.Implies); err != nil {
+			return
+		}
+		implications = append(implications, implication)
+	}
+
+	err = rows.Err()
+
+	return
+}
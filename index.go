@@ -1,26 +1,31 @@
 package booru
 
 import (
+	"bufio"
 	"context"
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sync"
 )
 
-// TODO removal of failed index generation should probably be some kind of recovery
-
 // database statements
 const (
 	StatementQueryTags        = "select tags.tag from tags order by tags.tag"
 	StatementQueryTaggedPosts = `
-select posts.id, posts.timestamp, posts.post from posts join relations on posts.id = relations.post join tags on relations.tag = tags.id where tags.tag=? order by posts.timestamp desc, posts.post desc`
-	StatementQueryEveryPost = `select posts.id, posts.timestamp, posts.post from posts order by posts.timestamp desc, posts.post desc`
+select ` + postColumns + ` from posts join relations on posts.id = relations.post join tags on relations.tag = tags.id where tags.tag=? order by posts.timestamp desc, posts.post desc`
+	StatementQueryEveryPost = `select ` + postColumns + ` from posts order by posts.timestamp desc, posts.post desc`
+)
+
+// errors
+var (
+	ErrorNoMatchingTags = errors.New("regex: no matching tags")
 )
 
 const globalIndexTag = "\000"
@@ -36,33 +41,62 @@ func indexExists(path string) bool {
 }
 
 func (b *Booru) indexStreamCancelable(tag string) CancelableStream {
-	return func(ctx context.Context) <-chan Post {
+	return func(ctx context.Context) Stream {
 		return b.indexStream(ctx, tag)
 	}
 }
 
-func (b *Booru) indexStream(ctx context.Context, tag string) <-chan Post {
+func (b *Booru) indexStream(ctx context.Context, tag string) Stream {
+	return b.indexStreamFrom(ctx, tag, nil)
+}
+
+// stream an index starting from the first post for which before is false;
+// before must be true for a (possibly empty) prefix of the index only
+func (b *Booru) indexStreamFrom(ctx context.Context, tag string, before func(Post) bool) Stream {
 	resultFull := make(chan Post)
+	var err error
 
 	go func(result chan<- Post) {
 		defer close(resultFull)
 
-		b.generateIndex(ctx, tag)
+		// failures caused by cancellation aren't errors
+		defer func() {
+			if ctx.Err() != nil {
+				err = nil
+			}
+		}()
 
-		index, err := os.Open(b.indexPath(tag))
+		// the index may be invalidated between generating and opening it
+		var index *os.File
+		for retry := 0; retry < 3; retry++ {
+			if err = b.generateIndex(ctx, tag); err != nil {
+				break
+			}
+			if index, err = os.Open(b.indexPath(tag)); !os.IsNotExist(err) {
+				break
+			}
+		}
 		if err != nil {
-			log.Printf("%v", err)
 			return
 		}
 		defer index.Close()
 
+		if before != nil {
+			var offset int64
+			if offset, err = seekIndex(index, before); err != nil {
+				return
+			}
+			if _, err = index.Seek(offset, io.SeekStart); err != nil {
+				return
+			}
+		}
+
 		decoder := json.NewDecoder(index)
 
 		for decoder.More() {
 			var post Post
-			err := decoder.Decode(&post)
-			if err != nil {
-				log.Printf("%v", err)
+			if err = decoder.Decode(&post); err != nil {
+				err = fmt.Errorf("index of %q: %w", tag, err)
 				return
 			}
 
@@ -74,11 +108,121 @@ func (b *Booru) indexStream(ctx context.Context, tag string) <-chan Post {
 		}
 	}(resultFull)
 
-	return resultFull
+	return Stream{resultFull, &err}
+}
+
+// read the value on the line starting at offset, returning the offset of the
+// next line
+func readIndexLine(index *os.File, offset int64, v interface{}) (next int64, err error) {
+	var line []byte
+	line, err = bufio.NewReader(io.NewSectionReader(index, offset, 1<<62)).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return
+	}
+	next = offset + int64(len(line))
+	err = json.Unmarshal(line, v)
+	return
+}
+
+// find the first line start at or after offset (or the end of the index)
+func nextIndexLine(index *os.File, offset, size int64) (start int64, err error) {
+	if offset == 0 {
+		return 0, nil
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(index, offset-1, size-offset+1))
+	var skipped []byte
+	if skipped, err = reader.ReadBytes('\n'); err == io.EOF {
+		return size, nil
+	}
+	return offset - 1 + int64(len(skipped)), err
+}
+
+// binary search an index for the offset of the first post for which before is
+// false
+func seekIndex(index *os.File, before func(Post) bool) (offset int64, err error) {
+	return seekLines(index, func(offset int64) (next int64, isBefore bool, err error) {
+		var post Post
+		if next, err = readIndexLine(index, offset, &post); err != nil {
+			return
+		}
+		return next, before(post), nil
+	})
+}
+
+// binary search a sorted index of tags for the offset of the first tag not
+// less than tag
+func seekTagIndex(index *os.File, tag string) (offset int64, err error) {
+	return seekLines(index, func(offset int64) (next int64, isBefore bool, err error) {
+		var line string
+		if next, err = readIndexLine(index, offset, &line); err != nil {
+			return
+		}
+		return next, line < tag, nil
+	})
+}
+
+// binary search the lines of an index, given a function which reads the line
+// at an offset and reports whether it is before the one sought
+func seekLines(index *os.File, read func(int64) (int64, bool, error)) (offset int64, err error) {
+	var info os.FileInfo
+	if info, err = index.Stat(); err != nil {
+		return
+	}
+
+	// lo and hi are line starts; every line before lo is before, and hi is
+	// the end of the index or a line which is not
+	lo, hi := int64(0), info.Size()
+	for lo < hi {
+		var start int64
+		if start, err = nextIndexLine(index, (lo+hi)/2, info.Size()); err != nil {
+			return
+		}
+
+		// the line at lo is the only one left
+		if start >= hi {
+			start = lo
+		}
+
+		var next int64
+		var before bool
+		if next, before, err = read(start); err != nil {
+			return
+		}
+
+		if before {
+			lo = next
+		} else {
+			hi = start
+		}
+	}
+
+	return lo, nil
 }
 
 var indexMutex sync.Mutex
 
+// write an index to a temporary file and move it into place, so that readers
+// never see a partially written index
+func createIndex(indexPath string, write func(*json.Encoder) error) (err error) {
+	var index *os.File
+	if index, err = os.CreateTemp(filepath.Dir(indexPath), ".index-*"); err != nil {
+		return
+	}
+	defer os.Remove(index.Name())
+	defer index.Close()
+
+	if err = write(json.NewEncoder(index)); err != nil {
+		return
+	}
+
+	if err = index.Close(); err != nil {
+		return
+	}
+
+	return os.Rename(index.Name(), indexPath)
+}
+
 func (b *Booru) generateIndex(ctx context.Context, tag string) (err error) {
 	indexPath := b.indexPath(tag)
 	if indexExists(indexPath) {
@@ -92,14 +236,6 @@ func (b *Booru) generateIndex(ctx context.Context, tag string) (err error) {
 		return
 	}
 
-	var index *os.File
-	if index, err = os.Create(indexPath); err != nil {
-		return
-	}
-	defer index.Close()
-
-	encoder := json.NewEncoder(index)
-
 	var rows *sql.Rows
 	if tag == globalIndexTag {
 		rows, err = b.db.QueryContext(ctx, StatementQueryEveryPost)
@@ -111,27 +247,19 @@ func (b *Booru) generateIndex(ctx context.Context, tag string) (err error) {
 	}
 	defer rows.Close()
 
-	for rows.Next() {
-		var post Post
-		if err = rows.Scan(&post.ID, &post.Time, &post.Post); err != nil {
-			index.Close()
-			os.Remove(indexPath)
-			return
-		}
-		if err = encoder.Encode(post); err != nil {
-			index.Close()
-			os.Remove(indexPath)
-			return
+	return createIndex(indexPath, func(encoder *json.Encoder) (err error) {
+		for rows.Next() {
+			var post Post
+			if err = rows.Scan(post.fields()...); err != nil {
+				return
+			}
+			if err = encoder.Encode(post); err != nil {
+				return
+			}
 		}
-	}
-
-	if err = rows.Err(); err != nil {
-		index.Close()
-		os.Remove(indexPath)
-		return
-	}
 
-	return
+		return rows.Err()
+	})
 }
 
 func (b *Booru) GenerateTagIndex(ctx context.Context) (err error) {
@@ -140,39 +268,100 @@ func (b *Booru) GenerateTagIndex(ctx context.Context) (err error) {
 		return
 	}
 
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+
+	if indexExists(indexPath) {
+		return
+	}
+
 	var rows *sql.Rows
 	if rows, err = b.db.QueryContext(ctx, StatementQueryTags); err != nil {
 		return
 	}
+	defer rows.Close()
 
-	var index *os.File
-	if index, err = os.Create(indexPath); err != nil {
+	return createIndex(indexPath, func(encoder *json.Encoder) (err error) {
+		for rows.Next() {
+			var tag string
+			if err = rows.Scan(&tag); err != nil {
+				return
+			}
+			if err = encoder.Encode(tag); err != nil {
+				return
+			}
+		}
+
+		return rows.Err()
+	})
+}
+
+// Mark the indexes of the given tags (and the tag index itself) as stale so
+// that they are regenerated on next use. Pass globalIndexTag when the set of
+// posts has changed.
+func (b *Booru) invalidateIndexes(tags ...string) (err error) {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+
+	paths := []string{filepath.Join(b.index, tagIndexName)}
+	for _, tag := range tags {
+		paths = append(paths, b.indexPath(tag))
+	}
+
+	for _, path := range paths {
+		if rmErr := os.Remove(path); rmErr != nil && !os.IsNotExist(rmErr) && err == nil {
+			err = rmErr
+		}
+	}
+
+	return
+}
+
+// Remove every index; they are regenerated on next use. The memory index, if
+// any, is reloaded.
+func (b *Booru) clearIndexes(ctx context.Context) (err error) {
+	if err = b.memory.reload(ctx, b.db); err != nil {
 		return
 	}
-	defer index.Close()
 
-	encoder := json.NewEncoder(index)
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
 
-	for rows.Next() {
-		var tag string
-		if err = rows.Scan(&tag); err != nil {
-			index.Close()
-			os.Remove(indexPath)
-			return
+	var entries []os.DirEntry
+	if entries, err = os.ReadDir(b.index); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
 		}
-		if err = encoder.Encode(tag); err != nil {
-			index.Close()
-			os.Remove(indexPath)
+		if _, hexErr := hex.DecodeString(entry.Name()); hexErr != nil && entry.Name() != tagIndexName {
+			continue
+		}
+		if err = os.Remove(filepath.Join(b.index, entry.Name())); err != nil && !os.IsNotExist(err) {
 			return
 		}
+		err = nil
 	}
 
-	if err = rows.Err(); err != nil {
-		index.Close()
-		os.Remove(indexPath)
-		return
-	}
+	return
+}
 
+// open the tag index, generating it if needed
+func (b *Booru) openTagIndex(ctx context.Context) (index *os.File, err error) {
+	// the index may be invalidated between generating and opening it
+	for retry := 0; retry < 3; retry++ {
+		if err = b.GenerateTagIndex(ctx); err != nil {
+			return
+		}
+		if index, err = os.Open(filepath.Join(b.index, tagIndexName)); !os.IsNotExist(err) {
+			break
+		}
+	}
 	return
 }
 
@@ -183,7 +372,7 @@ func (b *Booru) generateTagQuery(ctx context.Context, pattern string) (query str
 	}
 
 	var index *os.File
-	if index, err = os.Open(filepath.Join(b.index, tagIndexName)); err != nil {
+	if index, err = b.openTagIndex(ctx); err != nil {
 		return
 	}
 	defer index.Close()
@@ -198,12 +387,12 @@ func (b *Booru) generateTagQuery(ctx context.Context, pattern string) (query str
 		}
 
 		if regex.MatchString(tag) {
-			query = query + "~" + tag + " "
+			query = query + "~" + TagQuery(tag) + " "
 		}
 	}
 
 	if len(query) == 0 {
-		err = errors.New("regex: no matching tags")
+		err = ErrorNoMatchingTags
 	}
 
 	return
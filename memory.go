@@ -0,0 +1,515 @@
+package booru
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/dhlk/booru/parse"
+)
+
+// database statements
+const (
+	StatementQueryTaggedPostIDs = "select relations.post from relations join tags on relations.tag = tags.id where tags.tag = ?"
+	StatementQueryRelationTags  = "select relations.post, tags.tag from relations join tags on relations.tag = tags.id"
+)
+
+// a matching set smaller than this fraction of every post is sorted on its
+// own rather than picked out of the posts in index order
+const memorySparseRatio = 16
+
+// An in-memory alternative to the index files for plain tags: a bitmap of
+// the posts of each tag, which queries are evaluated on with set operations
+// rather than by merging streams decoded from the files. Writes update it in
+// place once they are committed.
+//
+// Bitmaps and posts are never changed, only replaced by changed copies, so
+// the mutex is only held to look them up or swap them, and never over I/O.
+type memoryIndex struct {
+	mutex   sync.Mutex
+	posts   *memoryPosts
+	tags    map[string]*bitmap // tags with no posts are missing
+	version int64              // counts changes, so that a reload can tell it raced one
+}
+
+// the posts of a memory index
+type memoryPosts struct {
+	byID   map[int64]Post
+	ranked []Post // in index order, newest first
+	every  *bitmap
+}
+
+// A change made by a committed write, to be applied to the memory index.
+type memoryWrite struct {
+	id       int64
+	post     *Post // the post as added or changed
+	removed  bool
+	tagged   []string
+	untagged []string
+}
+
+// the indexes made stale by a write
+func (w memoryWrite) stale() []string {
+	if w.post != nil {
+		return append([]string{globalIndexTag}, w.tagged...)
+	}
+	return w.tagged
+}
+
+// Load every post and tag into memory, and answer the parts of queries made
+// up of plain tags from there from now on. The index files are still used
+// for dates, similar images, patterns and the like.
+func (b *Booru) LoadMemoryIndex(ctx context.Context) (err error) {
+	memory := &memoryIndex{}
+	if err = memory.reload(ctx, b.db); err != nil {
+		return
+	}
+
+	b.memory = memory
+
+	return
+}
+
+// Reload tags from the database after a change too broad to apply as writes,
+// or with no tags, everything. The loading is done without the lock, and
+// done again if a write was applied meanwhile.
+func (m *memoryIndex) reload(ctx context.Context, db querier, tags ...string) (err error) {
+	if m == nil {
+		return
+	}
+
+	for {
+		m.mutex.Lock()
+		version := m.version
+		m.mutex.Unlock()
+
+		var posts *memoryPosts
+		loaded := map[string]*bitmap{}
+		if len(tags) == 0 {
+			if posts, err = loadMemoryPosts(ctx, db); err != nil {
+				return
+			}
+			if loaded, err = loadMemoryTags(ctx, db); err != nil {
+				return
+			}
+		} else {
+			for _, tag := range tags {
+				if loaded[tag], err = loadMemoryTag(ctx, db, tag); err != nil {
+					return
+				}
+			}
+		}
+
+		m.mutex.Lock()
+		if m.version != version {
+			m.mutex.Unlock()
+			continue
+		}
+
+		m.version++
+		if posts != nil {
+			m.posts, m.tags = posts, loaded
+		} else {
+			for tag, set := range loaded {
+				if set.cardinality() == 0 {
+					delete(m.tags, tag)
+				} else {
+					m.tags[tag] = set
+				}
+			}
+		}
+		m.mutex.Unlock()
+
+		return
+	}
+}
+
+func loadMemoryPosts(ctx context.Context, db querier) (posts *memoryPosts, err error) {
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, StatementQueryEveryPost); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	posts = &memoryPosts{byID: map[int64]Post{}, every: newBitmap()}
+	for rows.Next() {
+		var post Post
+		if err = rows.Scan(post.fields()...); err != nil {
+			return
+		}
+		posts.byID[post.ID] = post
+		posts.ranked = append(posts.ranked, post)
+		posts.every.add(post.ID)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+func loadMemoryTags(ctx context.Context, db querier) (tags map[string]*bitmap, err error) {
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, StatementQueryRelationTags); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	tags = map[string]*bitmap{}
+	for rows.Next() {
+		var post int64
+		var tag string
+		if err = rows.Scan(&post, &tag); err != nil {
+			return
+		}
+
+		if tags[tag] == nil {
+			tags[tag] = newBitmap()
+		}
+		tags[tag].add(post)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+// the posts of a tag, as it is written in the relations (that is, without
+// aliases or implications)
+func loadMemoryTag(ctx context.Context, db querier, tag string) (set *bitmap, err error) {
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, StatementQueryTaggedPostIDs, tag); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	set = newBitmap()
+	for rows.Next() {
+		var post int64
+		if err = rows.Scan(&post); err != nil {
+			return
+		}
+		set.add(post)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+// apply committed writes
+func (m *memoryIndex) apply(writes ...memoryWrite) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.version++
+
+	changedByID := map[int64]Post{}
+	removed := map[int64]bool{}
+	for _, write := range writes {
+		for _, tag := range write.tagged {
+			m.tags[tag] = m.tags[tag].with(write.id)
+		}
+		for _, tag := range write.untagged {
+			if set := m.tags[tag].without(write.id); set == nil || set.cardinality() == 0 {
+				delete(m.tags, tag)
+			} else {
+				m.tags[tag] = set
+			}
+		}
+
+		if write.removed {
+			removed[write.id] = true
+			delete(changedByID, write.id)
+		} else if write.post != nil {
+			post := *write.post
+			post.Tags = nil
+			changedByID[post.ID] = post
+			delete(removed, post.ID)
+		}
+	}
+
+	changed := make([]Post, 0, len(changedByID))
+	for _, post := range changedByID {
+		changed = append(changed, post)
+	}
+	if len(changed) > 0 || len(removed) > 0 {
+		m.posts = m.posts.with(changed, removed)
+	}
+}
+
+// a copy of p with posts added or replaced, and others removed
+func (p *memoryPosts) with(changed []Post, removed map[int64]bool) *memoryPosts {
+	result := &memoryPosts{byID: make(map[int64]Post, len(p.byID)+len(changed)), every: p.every}
+
+	replaced := map[int64]bool{}
+	for _, post := range changed {
+		replaced[post.ID] = true
+	}
+
+	for id, post := range p.byID {
+		if !removed[id] && !replaced[id] {
+			result.byID[id] = post
+		}
+	}
+	for id := range removed {
+		result.every = result.every.without(id)
+	}
+	for _, post := range changed {
+		result.byID[post.ID] = post
+		result.every = result.every.with(post.ID)
+	}
+
+	// merge the changed posts into the rest, which are still in order
+	sort.Slice(changed, func(i, j int) bool {
+		return ComparePostDescending(changed[i], changed[j]) == -1
+	})
+	result.ranked = make([]Post, 0, len(result.byID))
+	for _, post := range p.ranked {
+		if removed[post.ID] || replaced[post.ID] {
+			continue
+		}
+		for len(changed) > 0 && ComparePostDescending(changed[0], post) == -1 {
+			result.ranked = append(result.ranked, changed[0])
+			changed = changed[1:]
+		}
+		result.ranked = append(result.ranked, post)
+	}
+	result.ranked = append(result.ranked, changed...)
+
+	return result
+}
+
+func (m *memoryIndex) snapshot() *memoryPosts {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.posts
+}
+
+func (m *memoryIndex) tag(tag string) *bitmap {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if set := m.tags[tag]; set != nil {
+		return set
+	}
+	return newBitmap()
+}
+
+// whether a node is made up only of plain tags, and so can be evaluated in
+// memory
+func memoryEvaluable(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.CondNode:
+		for _, node := range n.And {
+			if !memoryEvaluable(node) {
+				return false
+			}
+		}
+		for _, node := range n.Or {
+			if !memoryEvaluable(node) {
+				return false
+			}
+		}
+		return true
+	case *parse.LessNode:
+		return memoryEvaluable(n.Less)
+	case *parse.WordNode:
+		return plainTag(n)
+	}
+	return false
+}
+
+// gather the plain tag terms of a clause which can't be evaluated in memory as
+// a whole into a clause of their own, so that they are intersected as bitmaps
+// rather than as streams; they are left alone unless one of them is positive,
+// as the complement of only negated tags is nearly every post
+func gatherMemoryTerms(cond *parse.CondNode) *parse.CondNode {
+	var plain, rest []parse.Node
+	positive := false
+	for _, node := range cond.And {
+		if !memoryEvaluable(node) {
+			rest = append(rest, node)
+			continue
+		}
+		plain = append(plain, node)
+		if _, less := node.(*parse.LessNode); !less {
+			positive = true
+		}
+	}
+
+	if len(plain) < 2 || !positive {
+		return cond
+	}
+
+	gathered := &parse.CondNode{NodeType: parse.NodeCond, And: plain}
+	return &parse.CondNode{NodeType: parse.NodeCond, And: append(rest, gathered), Or: cond.Or}
+}
+
+// stream a node of plain tags from the memory index, in the order of the query
+func (b *Booru) memoryStream(node parse.Node, options queryOptions) CancelableStream {
+	step, _ := options.step("Bitmap", parse.Format(node))
+	return step.wrap(func(ctx context.Context) Stream {
+		posts := b.memory.snapshot()
+
+		set, err := b.memory.eval(ctx, b.db, posts.every, node)
+		if err != nil {
+			return failedStream(ctx, err)
+		}
+
+		return posts.stream(ctx, set, options)
+	})
+}
+
+// stream the posts in a set, less those seen on earlier pages, in order;
+// only sparse sets, and orders the posts aren't kept in, are sorted
+func (p *memoryPosts) stream(ctx context.Context, set *bitmap, options queryOptions) Stream {
+	dense := set.cardinality()*memorySparseRatio >= len(p.ranked)
+
+	switch {
+	case options.order.Name == OrderID.Name:
+		from := int64(0)
+		if options.after != nil {
+			from = options.after.ID + 1
+		}
+		return generatedStream(ctx, func(yield func(Post) bool) {
+			set.eachFrom(from, func(id int64) bool {
+				post, ok := p.byID[id]
+				return !ok || yield(post)
+			})
+		})
+
+	case dense && options.order.Name == OrderDescending.Name:
+		start := sort.Search(len(p.ranked), func(i int) bool { return !options.seen(p.ranked[i]) })
+		return generatedStream(ctx, func(yield func(Post) bool) {
+			for _, post := range p.ranked[start:] {
+				if set.contains(post.ID) && !yield(post) {
+					return
+				}
+			}
+		})
+
+	case dense && options.order.Name == OrderAscending.Name:
+		end := sort.Search(len(p.ranked), func(i int) bool { return options.seen(p.ranked[i]) })
+		return generatedStream(ctx, func(yield func(Post) bool) {
+			for i := end - 1; i >= 0; i-- {
+				if set.contains(p.ranked[i].ID) && !yield(p.ranked[i]) {
+					return
+				}
+			}
+		})
+	}
+
+	posts := make([]Post, 0, set.cardinality())
+	set.each(func(id int64) bool {
+		if post, ok := p.byID[id]; ok && !options.seen(post) {
+			posts = append(posts, post)
+		}
+		return true
+	})
+
+	sort.Slice(posts, func(i, j int) bool {
+		return options.order.Compare(posts[i], posts[j]) == -1
+	})
+
+	return sliceStream(ctx, posts)
+}
+
+// stream the posts a generator yields; yield returns false once the stream is
+// canceled
+func generatedStream(ctx context.Context, generate func(yield func(Post) bool)) Stream {
+	result := make(chan Post)
+
+	go func(out chan<- Post) {
+		defer close(result)
+
+		generate(func(post Post) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case out <- post:
+				return true
+			}
+		})
+	}(result)
+
+	return Stream{C: result}
+}
+
+// evaluate a node as queryForNode does, with set operations in place of
+// stream ones
+func (m *memoryIndex) eval(ctx context.Context, db querier, every *bitmap, node parse.Node) (set *bitmap, err error) {
+	switch n := node.(type) {
+	case *parse.CondNode:
+		return m.evalCond(ctx, db, every, n)
+	case *parse.LessNode:
+		var less *bitmap
+		if less, err = m.eval(ctx, db, every, n.Less); err != nil {
+			return
+		}
+		return andNot(every, less), nil
+	case *parse.WordNode:
+		return m.evalTag(ctx, db, string(n.Word))
+	}
+
+	return nil, fmt.Errorf("memory index: unknown node %T", node)
+}
+
+func (m *memoryIndex) evalCond(ctx context.Context, db querier, every *bitmap, cond *parse.CondNode) (set *bitmap, err error) {
+	set = every
+
+	for _, node := range cond.And {
+		var term *bitmap
+		if less, ok := node.(*parse.LessNode); ok {
+			if term, err = m.eval(ctx, db, every, less.Less); err != nil {
+				return
+			}
+			set = andNot(set, term)
+			continue
+		}
+
+		if term, err = m.eval(ctx, db, every, node); err != nil {
+			return
+		}
+		set = and(set, term)
+	}
+
+	if len(cond.Or) > 0 {
+		union := newBitmap()
+		for _, node := range cond.Or {
+			var term *bitmap
+			if term, err = m.eval(ctx, db, every, node); err != nil {
+				return
+			}
+			union = or(union, term)
+		}
+		set = and(set, union)
+	}
+
+	return
+}
+
+// the posts of a tag, resolving aliases and including every tag which implies
+// it, as tagStream does
+func (m *memoryIndex) evalTag(ctx context.Context, db querier, tag string) (set *bitmap, err error) {
+	var canonical string
+	if canonical, err = canonicalTag(ctx, db, tag); err != nil {
+		return
+	}
+
+	var implying []string
+	if implying, err = closure(ctx, db, StatementQueryImpliedBy, canonical); err != nil {
+		return
+	}
+
+	set = m.tag(canonical)
+	for _, t := range implying {
+		set = or(set, m.tag(t))
+	}
+
+	return
+}
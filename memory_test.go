@@ -0,0 +1,138 @@
+package booru
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var memoryTestQueries = []string{
+	"",
+	"a",
+	"a b",
+	"a -b",
+	"-a",
+	"~a ~c",
+	"a -b ~c ~d",
+	"d",
+	"d -a",
+	"-(( a ~b ))",
+	"-(( -a ))",
+	"(( a -b )) ~c ~(( d ))",
+	"missing",
+	"-missing",
+	"a -missing",
+	"a b order:asc",
+	"d order:asc",
+	"a order:id",
+	"-c order:path",
+	"b order:random:3",
+	"a date:2020-01-03..",
+	"a b -c date:..2020-01-05 order:asc",
+}
+
+// every page of a query
+func allPages(t *testing.T, b *Booru, query string, length int64) (ids []int64) {
+	t.Helper()
+
+	cursor := ""
+	for {
+		posts, next, err := b.Query(context.Background(), query, cursor, length)
+		if err != nil {
+			t.Fatalf("%q: %v", query, err)
+		}
+		ids = append(ids, postIDs(posts)...)
+		if next == "" {
+			return
+		}
+		cursor = next
+	}
+}
+
+// the memory index gives the same results, in the same order and pages, as
+// the index files
+func compareMemoryIndex(t *testing.T, files, memory *Booru, when string) {
+	t.Helper()
+
+	for _, query := range memoryTestQueries {
+		want := allPages(t, files, query, 1000)
+		if got := allPages(t, memory, query, 1000); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %q: got %v, want %v", when, query, got, want)
+		}
+		if got := allPages(t, memory, query, 7); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %q in pages: got %v, want %v", when, query, got, want)
+		}
+	}
+}
+
+func TestMemoryIndex(t *testing.T) {
+	ctx := context.Background()
+
+	memory := testBooru(t)
+	ids := seedPosts(t, memory, 300, func(i int) (tags []string) {
+		for _, tag := range []struct {
+			name  string
+			every int
+		}{{"a", 2}, {"b", 3}, {"c", 5}, {"d", 50}} {
+			if i%tag.every == 0 {
+				tags = append(tags, tag.name)
+			}
+		}
+		return
+	})
+	files := New(memory.db, memory.index, memory.baseline)
+
+	if err := memory.LoadMemoryIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	compareMemoryIndex(t, files, memory, "loaded")
+
+	if err := memory.TagPost(ctx, ids[1], "a", "d", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := memory.UntagPost(ctx, ids[6], "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := memory.DeletePost(ctx, ids[10]); err != nil {
+		t.Fatal(err)
+	}
+	compareMemoryIndex(t, files, memory, "after writes")
+
+	if err := memory.AddImplication(ctx, "d", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := memory.AddAlias(ctx, "b", "new"); err != nil {
+		t.Fatal(err)
+	}
+	compareMemoryIndex(t, files, memory, "after alias and implication")
+
+	dir := t.TempDir()
+	for i, timestamp := range []time.Time{
+		time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC),
+		time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		path := filepath.Join(dir, fmt.Sprintf("%d.txt", i))
+		if err := os.WriteFile(path, []byte(path), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := memory.AddPostPolicy(ctx, path, timestamp, DuplicateReject, "a", "c"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	compareMemoryIndex(t, files, memory, "after adding posts")
+
+	seedPosts(t, memory, 20, func(i int) []string { return []string{"a", "c"} })
+	compareMemoryIndex(t, files, memory, "after reloading")
+}
+
+func TestMemoryIndexEval(t *testing.T) {
+	m := &memoryIndex{}
+	if _, err := m.eval(context.Background(), nil, newBitmap(), nil); err == nil {
+		t.Error("evaluating an unknown node: no error")
+	}
+}
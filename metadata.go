@@ -0,0 +1,165 @@
+package booru
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// database statements
+const (
+	StatementAddPostHash   = "alter table posts add column hash text not null default ''"
+	StatementAddPostSize   = "alter table posts add column size integer not null default 0"
+	StatementAddPostMIME   = "alter table posts add column mime text not null default ''"
+	StatementAddPostWidth  = "alter table posts add column width integer not null default 0"
+	StatementAddPostHeight = "alter table posts add column height integer not null default 0"
+
+	// posts added before their metadata (or perceptual hash) was recorded
+	StatementQueryStaleMetadata = "select " + postColumns + " from posts where hash = '' or (width > 0 and phash is null) order by posts.post"
+)
+
+// columns scanned by Post.fields
+const postColumns = "posts.id, posts.timestamp, posts.post, posts.hash, posts.size, posts.mime, posts.width, posts.height, posts.phash"
+
+// Metadata about the file behind a post. Width, Height and PHash are only
+// known for images the image package can decode.
+type Metadata struct {
+	Hash   string // hex SHA-256 of the contents
+	Size   int64
+	MIME   string
+	Width  int
+	Height int
+	PHash  PerceptualHash
+}
+
+// the perceptual hash as stored: NULL unless the post is a decoded image
+func (m Metadata) phash() sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(m.PHash), Valid: m.Width > 0}
+}
+
+// scan destinations matching postColumns
+func (p *Post) fields() []interface{} {
+	return []interface{}{&p.ID, &p.Time, &p.Post, &p.Hash, &p.Size, &p.MIME, &p.Width, &p.Height, &p.PHash}
+}
+
+// Read the metadata of a file.
+func ReadMetadata(path string) (metadata Metadata, err error) {
+	var file *os.File
+	if file, err = os.Open(path); err != nil {
+		return
+	}
+	defer file.Close()
+
+	// sniff the type from the head of the file while hashing it
+	hash := sha256.New()
+	head := make([]byte, 512)
+	var n int
+	if n, err = io.ReadFull(io.TeeReader(file, hash), head); err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return
+	}
+	metadata.MIME = http.DetectContentType(head[:n])
+
+	var rest int64
+	if rest, err = io.Copy(hash, file); err != nil {
+		return
+	}
+	metadata.Size = int64(n) + rest
+	metadata.Hash = hex.EncodeToString(hash.Sum(nil))
+
+	if strings.HasPrefix(metadata.MIME, "image/") {
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			return
+		}
+
+		// formats the image package can't decode just lack dimensions
+		if img, _, decodeErr := image.Decode(file); decodeErr == nil {
+			metadata.Width = img.Bounds().Dx()
+			metadata.Height = img.Bounds().Dy()
+			metadata.PHash = DHash(img)
+		}
+	}
+
+	return
+}
+
+// Read the metadata of the posts added before it was recorded: those with no
+// hash, and images with no perceptual hash. The migrations which add these
+// columns can't read every file, so this fills them in afterwards; with all
+// set every post is read again. Posts whose files are missing are left as
+// they are. Returns the number of posts whose metadata changed.
+func (b *Booru) RefreshMetadata(ctx context.Context, all bool) (refreshed int64, err error) {
+	statement := StatementQueryStaleMetadata
+	if all {
+		statement = StatementQueryEveryPostPath
+	}
+
+	var posts []Post
+	if posts, err = scanPosts(ctx, b.db, statement); err != nil {
+		return
+	}
+
+	// read the files before holding a transaction open
+	var changed, old []Post
+	for _, post := range posts {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		metadata, readErr := ReadMetadata(post.Post)
+		if os.IsNotExist(readErr) {
+			continue
+		} else if readErr != nil {
+			err = readErr
+			return
+		}
+
+		if metadata != post.Metadata {
+			old = append(old, post)
+			post.Metadata = metadata
+			changed = append(changed, post)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	var transaction *sql.Tx
+	if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer transaction.Rollback()
+
+	for _, post := range changed {
+		metadata := post.Metadata
+		if _, err = transaction.ExecContext(ctx, StatementUpdatePostMetadata, metadata.Hash, metadata.Size, metadata.MIME, metadata.Width, metadata.Height, metadata.phash(), post.ID); err != nil {
+			return
+		}
+	}
+
+	if err = transaction.Commit(); err != nil {
+		return
+	}
+	refreshed = int64(len(changed))
+
+	// indexes hold post metadata
+	if err = b.clearIndexes(ctx); err != nil {
+		return
+	}
+
+	for _, post := range old {
+		if err = b.pruneThumbnail(ctx, post.Metadata); err != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,153 @@
+package booru
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// database statements
+const (
+	StatementAddTagNamespace     = "alter table tags add column namespace text not null default ''"
+	StatementFillTagNamespace    = "update tags set namespace = substr(tag, 1, instr(tag, ':') - 1) where instr(tag, ':') > 1"
+	StatementCreateNamespaces    = "create table namespaces (namespace text not null primary key, description text not null default '')"
+	StatementFillNamespaces      = "insert or ignore into namespaces (namespace) select distinct namespace from tags where namespace != ''"
+	StatementQueryNamespaces     = "select namespace, description from namespaces order by namespace"
+	StatementQueryNamespaceTags  = "select tag from tags where namespace = ? order by tag"
+	StatementInsertNamespace     = "insert or ignore into namespaces (namespace) values (?)"
+	StatementUpsertNamespace     = "insert into namespaces (namespace, description) values (?, ?) on conflict (namespace) do update set description = excluded.description"
+	StatementDeleteNamespace     = "delete from namespaces where namespace = ?"
+	StatementQueryNamespaceInUse = "select count(*) from tags where namespace = ?"
+)
+
+// errors
+var (
+	ErrorReservedNamespace = errors.New("reserved namespace")
+	ErrorNamespaceInUse    = errors.New("namespace in use")
+)
+
+// Namespaces claimed by query predicates (baseline:, regex:, ...); tags may not
+// use them, so an unquoted query word with one of these prefixes is never a
+// tag. Tags stored before their namespace was reserved keep their posts but
+// can't be added to more, and are searched by quoting them ("date:2020", as
+// TagQuery writes); ReservedTags lists them.
+var ReservedNamespaces = []string{"baseline", "regex", "random", "similar", "date", "age", "order"}
+
+// A Namespace groups tags named "namespace:tag".
+type Namespace struct {
+	Namespace   string
+	Description string
+}
+
+// Split a tag into its namespace and name; tags without a namespace have an
+// empty namespace.
+func SplitTag(tag string) (namespace, name string) {
+	if i := strings.Index(tag, ":"); i > 0 {
+		return tag[:i], tag[i+1:]
+	}
+	return "", tag
+}
+
+func IsReservedNamespace(namespace string) bool {
+	for _, reserved := range ReservedNamespaces {
+		if namespace == reserved {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the tags in reserved namespaces, stored before the namespace was
+// reserved, in sorted order.
+func (b *Booru) ReservedTags(ctx context.Context) (tags []string, err error) {
+	namespaces := append([]string{}, ReservedNamespaces...)
+	sort.Strings(namespaces)
+
+	for _, namespace := range namespaces {
+		var inNamespace []string
+		if inNamespace, err = queryStrings(ctx, b.db, StatementQueryNamespaceTags, namespace); err != nil {
+			return
+		}
+		tags = append(tags, inNamespace...)
+	}
+
+	return
+}
+
+// Set the description of a namespace, creating it if needed.
+func (b *Booru) SetNamespace(ctx context.Context, namespace Namespace) (err error) {
+	if namespace.Namespace == "" || IsReservedNamespace(namespace.Namespace) {
+		return ErrorReservedNamespace
+	}
+
+	_, err = b.db.ExecContext(ctx, StatementUpsertNamespace, namespace.Namespace, namespace.Description)
+
+	return
+}
+
+// Remove a namespace which no tag uses.
+func (b *Booru) RemoveNamespace(ctx context.Context, namespace string) (err error) {
+	var transaction *sql.Tx
+	if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer transaction.Rollback()
+
+	var count int
+	if err = transaction.QueryRowContext(ctx, StatementQueryNamespaceInUse, namespace).Scan(&count); err != nil {
+		return
+	} else if count > 0 {
+		return ErrorNamespaceInUse
+	}
+
+	if _, err = transaction.ExecContext(ctx, StatementDeleteNamespace, namespace); err != nil {
+		return
+	}
+
+	return transaction.Commit()
+}
+
+// Get every namespace.
+func (b *Booru) Namespaces(ctx context.Context) (namespaces []Namespace, err error) {
+	return queryNamespaces(ctx, b.db)
+}
+
+func queryNamespaces(ctx context.Context, db querier) (namespaces []Namespace, err error) {
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, StatementQueryNamespaces); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var namespace Namespace
+		if err = rows.Scan(&namespace.Namespace, &namespace.Description); err != nil {
+			return
+		}
+		namespaces = append(namespaces, namespace)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+// stream the posts of every tag in a namespace, in index order
+func (b *Booru) namespaceStream(namespace string, options queryOptions) CancelableStream {
+	step, options := options.step("Namespace", namespace)
+	return step.wrap(func(ctx context.Context) Stream {
+		tags, err := queryStrings(ctx, b.db, StatementQueryNamespaceTags, namespace)
+		if err != nil {
+			return failedStream(ctx, err)
+		}
+
+		streams := make([]CancelableStream, len(tags))
+		for i, tag := range tags {
+			streams[i] = b.indexScan(tag, options)
+		}
+
+		return union(ctx, streams, OrderDescending.Compare)
+	})
+}
@@ -0,0 +1,60 @@
+package booru
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// errors
+var (
+	ErrorInvalidOrder = errors.New("invalid order")
+)
+
+// An Order sorts query results. It is chosen with an order: word at the top
+// level of a query, such as order:asc or order:random:42.
+type Order struct {
+	Name    string
+	Compare PostCompare
+}
+
+var (
+	OrderDescending = Order{"desc", ComparePostDescending} // newest first; the order of the indexes
+	OrderAscending  = Order{"asc", ComparePostAscending}   // oldest first
+	OrderPath       = Order{"path", ComparePostPath}
+	OrderID         = Order{"id", ComparePostID}
+)
+
+// Parse the argument of an order: word. random takes an optional seed,
+// defaulting to 0, so that every page of a query sees the same shuffle.
+func ParseOrder(name string) (order Order, err error) {
+	switch name {
+	case OrderDescending.Name:
+		return OrderDescending, nil
+	case OrderAscending.Name:
+		return OrderAscending, nil
+	case OrderPath.Name:
+		return OrderPath, nil
+	case OrderID.Name:
+		return OrderID, nil
+	}
+
+	if name == "random" || strings.HasPrefix(name, "random:") {
+		seed := int64(0)
+		if arg := strings.TrimPrefix(name, "random"); arg != "" {
+			if seed, err = strconv.ParseInt(arg[1:], 10, 64); err != nil {
+				err = ErrorInvalidOrder
+				return
+			}
+		}
+		return Order{name, ComparePostRandom(seed)}, nil
+	}
+
+	err = ErrorInvalidOrder
+	return
+}
+
+// whether posts from the indexes are already in this order
+func (o Order) indexed() bool {
+	return o.Name == OrderDescending.Name
+}
@@ -0,0 +1,45 @@
+package parse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Position locates a token in a query: a byte offset, and a line and
+// column (in characters) counted from 1.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func position(input string, offset int) Position {
+	lineStart := strings.LastIndexByte(input[:offset], '\n') + 1
+	return Position{
+		Offset: offset,
+		Line:   strings.Count(input[:offset], "\n") + 1,
+		Column: utf8.RuneCountInString(input[lineStart:offset]) + 1,
+	}
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// An Error is a query which failed to parse.
+type Error struct {
+	Query    string
+	Position Position
+	Token    string // the offending token; empty at the end of the query
+	Expected string // what was expected instead
+}
+
+func (e *Error) Error() string {
+	token := "end of query"
+	if e.Token != "" {
+		token = strconv.Quote(e.Token)
+	}
+	return fmt.Sprintf("%s: unexpected %s, expected %s", e.Position, token, e.Expected)
+}
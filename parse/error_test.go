@@ -0,0 +1,61 @@
+package parse
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		query string
+		err   Error // Query is the query itself
+		text  string
+	}{
+		{"((", Error{Position: Position{2, 1, 3}, Expected: `"))" to close clause`}, `1:3: unexpected end of query, expected "))" to close clause`},
+		{"(( a", Error{Position: Position{4, 1, 5}, Expected: `"))" to close clause`}, `1:5: unexpected end of query, expected "))" to close clause`},
+		{"a ))", Error{Position: Position{2, 1, 3}, Token: "))", Expected: "word or clause"}, `1:3: unexpected "))", expected word or clause`},
+		{"~", Error{Position: Position{1, 1, 2}, Expected: "word or clause to or"}, "1:2: unexpected end of query, expected word or clause to or"},
+		// columns count characters, not bytes
+		{"é ))", Error{Position: Position{3, 1, 3}, Token: "))", Expected: "word or clause"}, `1:3: unexpected "))", expected word or clause`},
+		// multiple lines
+		{"a\nb ((\nc", Error{Position: Position{8, 3, 2}, Expected: `"))" to close clause`}, `3:2: unexpected end of query, expected "))" to close clause`},
+		{"a (( b ))\n))", Error{Position: Position{10, 2, 1}, Token: "))", Expected: "word or clause"}, `2:1: unexpected "))", expected word or clause`},
+		{"a\n  ~", Error{Position: Position{5, 2, 4}, Expected: "word or clause to or"}, "2:4: unexpected end of query, expected word or clause to or"},
+		{"a\n -", Error{Position: Position{4, 2, 3}, Expected: "word or clause to negate"}, "2:3: unexpected end of query, expected word or clause to negate"},
+		{"a\n\"b", Error{Position: Position{2, 2, 1}, Token: `"b`, Expected: "closing quote"}, `2:1: unexpected "\"b", expected closing quote`},
+		{"a\n\n  ((  ", Error{Position: Position{9, 3, 7}, Expected: `"))" to close clause`}, `3:7: unexpected end of query, expected "))" to close clause`},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.query)
+
+		var got *Error
+		if !errors.As(err, &got) {
+			t.Errorf("%q: got %v, want a parse error", test.query, err)
+			continue
+		}
+
+		want := test.err
+		want.Query = test.query
+		if *got != want {
+			t.Errorf("%q: got %+v, want %+v", test.query, *got, want)
+		}
+		if got.Error() != test.text {
+			t.Errorf("%q: got %q, want %q", test.query, got.Error(), test.text)
+		}
+	}
+}
+
+// an empty query is no error, and has no terms
+func TestErrorEmpty(t *testing.T) {
+	for _, query := range []string{"", "   ", "\n\n"} {
+		tree, err := Parse(query)
+		if err != nil {
+			t.Errorf("%q: %v", query, err)
+			continue
+		}
+		if got := dump(tree.Root); got != "cond()" {
+			t.Errorf("%q: got %s, want no terms", query, got)
+		}
+	}
+}
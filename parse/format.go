@@ -0,0 +1,87 @@
+package parse
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Format a node as query syntax which parses back to the same tree. A
+// CondNode is written as a whole query, without the symbols around it. A
+// negated negation, which Parse never makes, is written as what it negates,
+// and a word which would lex as something else is quoted, so parses back as
+// Quoted.
+func Format(node Node) string {
+	var b strings.Builder
+	if cond, ok := node.(*CondNode); ok {
+		formatTerms(&b, cond)
+	} else {
+		formatNode(&b, node)
+	}
+	return b.String()
+}
+
+func formatTerms(b *strings.Builder, cond *CondNode) {
+	first := true
+	space := func() {
+		if !first {
+			b.WriteByte(' ')
+		}
+		first = false
+	}
+
+	for _, node := range cond.And {
+		space()
+		formatNode(b, node)
+	}
+	for _, node := range cond.Or {
+		space()
+		b.WriteString(symbolOr)
+		formatNode(b, node)
+	}
+}
+
+func formatNode(b *strings.Builder, node Node) {
+	switch n := node.(type) {
+	case *CondNode:
+		b.WriteString(symbolOpen)
+		b.WriteByte(' ')
+		formatTerms(b, n)
+		if len(n.And)+len(n.Or) > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(symbolClose)
+	case *LessNode:
+		if less, ok := n.Less.(*LessNode); ok {
+			// only a word or clause may be negated, and a negation in a
+			// clause parses as a clause; the two negations cancel out
+			formatNode(b, less.Less)
+			return
+		}
+		b.WriteString(symbolLess)
+		formatNode(b, n.Less)
+	case *WordNode:
+		b.WriteString(formatWord(string(n.Word), n.Quoted))
+	}
+}
+
+// quote a word if it was quoted, or if it would otherwise lex as something
+// else (and so parses back as quoted)
+func formatWord(word string, quoted bool) string {
+	if quoted || needsQuote(word) {
+		return strconv.Quote(word)
+	}
+	return word
+}
+
+func needsQuote(word string) bool {
+	if word == "" || strings.HasPrefix(word, quote) {
+		return true
+	}
+	for _, symbol := range symbols {
+		if strings.HasPrefix(word, symbol) {
+			return true
+		}
+	}
+	return strings.IndexFunc(word, unicode.IsSpace) != -1
+}
@@ -0,0 +1,175 @@
+package parse
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// a tree as a string which shows its whole structure
+func dump(node Node) string {
+	switch n := node.(type) {
+	case *CondNode:
+		s := "cond("
+		for _, term := range n.And {
+			s += dump(term) + " "
+		}
+		for _, term := range n.Or {
+			s += "or " + dump(term) + " "
+		}
+		return s + ")"
+	case *LessNode:
+		return "less(" + dump(n.Less) + ")"
+	case *WordNode:
+		if n.Quoted {
+			return fmt.Sprintf("quoted(%q)", n.Word)
+		}
+		return fmt.Sprintf("%q", n.Word)
+	}
+	return fmt.Sprintf("%T", node)
+}
+
+// the tree Parse makes of what Format writes for node
+func normalize(node Node) Node {
+	switch n := node.(type) {
+	case *CondNode:
+		cond := n.tr.newCond()
+		for _, term := range n.And {
+			cond.and(normalize(term))
+		}
+		for _, term := range n.Or {
+			cond.or(normalize(term))
+		}
+		return cond
+	case *LessNode:
+		if less, ok := n.Less.(*LessNode); ok {
+			return normalize(less.Less)
+		}
+		return n.tr.newLess(normalize(n.Less))
+	case *WordNode:
+		word := n.Copy().(*WordNode)
+		word.Quoted = word.Quoted || needsQuote(string(word.Word))
+		return word
+	}
+	return node
+}
+
+var formatTestWords = []string{
+	"a", "tag_name", "date:2020", "what?", "a*", "-", "~", "((", "))",
+	"-a", "~a", "((a", "a))", "a-b", "", " ", "a b", `"`, `"a"`, `a"b`, "\\", "\n", "日本",
+}
+
+// a random tree, with nodes that Parse would not make
+func randomNode(r *rand.Rand, t *Tree, depth int) Node {
+	switch n := r.Intn(4); {
+	case depth > 3 || n == 0:
+		word := t.newWord(formatTestWords[r.Intn(len(formatTestWords))])
+		word.Quoted = r.Intn(4) == 0
+		return word
+	case n == 1:
+		return t.newLess(randomNode(r, t, depth+1))
+	default:
+		return randomCond(r, t, depth+1)
+	}
+}
+
+func randomCond(r *rand.Rand, t *Tree, depth int) *CondNode {
+	cond := t.newCond()
+	for i := r.Intn(4); i > 0; i-- {
+		cond.and(randomNode(r, t, depth))
+	}
+	for i := r.Intn(3); i > 0; i-- {
+		cond.or(randomNode(r, t, depth))
+	}
+	return cond
+}
+
+func TestFormat(t *testing.T) {
+	tr := New()
+	word := func(w string) Node { return tr.newWord(w) }
+	quoted := func(w string) Node {
+		word := tr.newWord(w)
+		word.Quoted = true
+		return word
+	}
+	less := func(n Node) Node { return tr.newLess(n) }
+	cond := func(and []Node, or ...Node) *CondNode {
+		c := tr.newCond()
+		c.And, c.Or = and, or
+		return c
+	}
+
+	tests := []struct {
+		tree  *CondNode
+		query string
+	}{
+		{cond(nil), ""},
+		{cond([]Node{word("a"), word("b")}), "a b"},
+		{cond([]Node{word("a")}, word("b"), word("c")), "a ~b ~c"},
+		{cond([]Node{less(word("a"))}), "-a"},
+		{cond([]Node{less(cond([]Node{word("a")}, word("b")))}), "-(( a ~b ))"},
+		{cond([]Node{cond(nil)}), "(( ))"},
+		{cond([]Node{word("-a"), word("a b"), word("")}), `"-a" "a b" ""`},
+		{cond([]Node{quoted("date:2020"), less(quoted("what?"))}), `"date:2020" -"what?"`},
+		{cond([]Node{less(less(word("a")))}), "a"},
+		{cond([]Node{less(less(less(word("a"))))}), "-a"},
+		{cond([]Node{less(cond([]Node{less(word("a"))}))}), "-(( -a ))"},
+		{cond(nil, less(less(cond([]Node{word("a")})))), "~(( a ))"},
+	}
+
+	for _, test := range tests {
+		if got := Format(test.tree); got != test.query {
+			t.Errorf("%s: formatted %q, want %q", dump(test.tree), got, test.query)
+		}
+	}
+}
+
+// Parse(Format(tree)) is the tree, with double negations cancelled out
+func TestFormatParse(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		tree := randomCond(r, New(), 0)
+		query := Format(tree)
+
+		parsed, err := Parse(query)
+		if err != nil {
+			t.Fatalf("%s: formatted %q: %v", dump(tree), query, err)
+		}
+		if got, want := dump(parsed.Root), dump(normalize(tree)); got != want {
+			t.Fatalf("formatted %q: parsed %s, want %s", query, got, want)
+		}
+	}
+}
+
+// any query which parses formats as one which parses to the same tree
+func FuzzFormat(f *testing.F) {
+	for _, query := range []string{"", "a -b ~c", "-(( -a ))", `"a b" ((c ~d))`, "-(( (( a )) ))", `"order:asc" -"a*"`} {
+		f.Add(query)
+	}
+	f.Fuzz(func(t *testing.T, query string) {
+		tree, err := Parse(query)
+		if err != nil {
+			return
+		}
+		formatted := Format(tree.Root)
+
+		again, err := Parse(formatted)
+		if err != nil {
+			t.Fatalf("%q formatted %q: %v", query, formatted, err)
+		}
+		if got, want := dump(again.Root), dump(tree.Root); got != want {
+			t.Fatalf("%q formatted %q: parsed %s, want %s", query, formatted, got, want)
+		}
+	})
+}
+
+func TestParseQuoted(t *testing.T) {
+	tree, err := Parse(`date:2020 "date:2020" -"a*" ~"b c"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `cond("date:2020" quoted("date:2020") less(quoted("a*")) or quoted("b c") )`
+	if got := dump(tree.Root); got != want {
+		t.Errorf("parsed %s, want %s", got, want)
+	}
+}
@@ -2,6 +2,7 @@ package parse
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -10,13 +11,14 @@ import (
 type itemType int
 
 const (
-	itemError itemType = iota // error
-	itemLess                  // -word
-	itemOr                    // ~word
-	itemOpen                  // (word
-	itemClose                 // word)
-	itemWord                  // word
-	itemEOF                   // EOF
+	itemError  itemType = iota // error; the value is what was expected
+	itemLess                   // -word
+	itemOr                     // ~word
+	itemOpen                   // (word
+	itemClose                  // word)
+	itemWord                   // word
+	itemQuoted                 // "quoted word"
+	itemEOF                    // EOF
 )
 
 const eof = rune(-1)
@@ -30,9 +32,15 @@ const (
 
 var symbols = []string{symbolLess, symbolOr, symbolOpen, symbolClose}
 
+// a word starting with a quote is read up to the closing quote, with Go
+// string escapes, so that it may contain spaces or start with a symbol; it is
+// always a plain tag, whatever it looks like
+const quote = `"`
+
 type item struct {
 	typ itemType
 	val string
+	pos Position
 }
 
 func IsEOF(i item) bool {
@@ -40,7 +48,7 @@ func IsEOF(i item) bool {
 }
 
 func (i item) String() string {
-	return fmt.Sprintf("item<%v, %s>", i.typ, i.val)
+	return fmt.Sprintf("item<%v, %s, %s>", i.typ, i.val, i.pos)
 }
 
 type lexxer struct {
@@ -76,7 +84,7 @@ func (lex *lexxer) nextItem() item {
 }
 
 func (lex *lexxer) emit(t itemType) {
-	lex.items <- item{t, lex.input[lex.start:lex.pos]}
+	lex.items <- item{t, lex.input[lex.start:lex.pos], position(lex.input, lex.start)}
 	lex.start = lex.pos
 }
 
@@ -110,6 +118,7 @@ func (lex *lexxer) errorf(format string, args ...interface{}) stateFn {
 	lex.items <- item{
 		itemError,
 		fmt.Sprintf(format, args...),
+		position(lex.input, lex.start),
 	}
 	return nil
 }
@@ -130,11 +139,13 @@ func lexBase(lex *lexxer) stateFn {
 		r := lex.next()
 
 		if r == eof {
-			lex.items <- item{itemEOF, "EOF"}
+			lex.items <- item{itemEOF, "EOF", position(lex.input, lex.pos)}
 			return nil
 		} else if unicode.IsSpace(r) {
 			// eat space
 			lex.ignore()
+		} else if string(r) == quote {
+			return lexQuoted
 		} else {
 			// enter words
 			lex.backup()
@@ -159,6 +170,25 @@ func lexWords(lex *lexxer) stateFn {
 	}
 }
 
+func lexQuoted(lex *lexxer) stateFn {
+	for {
+		switch lex.next() {
+		case eof:
+			return lex.errorf("closing quote")
+		case '\\':
+			lex.next()
+		case '"':
+			word, err := strconv.Unquote(lex.input[lex.start:lex.pos])
+			if err != nil {
+				return lex.errorf("valid quoted word")
+			}
+			lex.items <- item{itemQuoted, word, position(lex.input, lex.start)}
+			lex.start = lex.pos
+			return lexBase
+		}
+	}
+}
+
 func lexSym(lex *lexxer, symbol string) stateFn {
 	var t itemType
 	switch symbol {
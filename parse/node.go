@@ -108,8 +108,9 @@ func (less *LessNode) Copy() Node {
 
 type WordNode struct {
 	NodeType
-	tr   *Tree
-	Word []byte
+	tr     *Tree
+	Word   []byte
+	Quoted bool // written in quotes: a plain tag, whatever it looks like
 }
 
 func (tree *Tree) newWord(word string) *WordNode {
@@ -125,5 +126,5 @@ func (word *WordNode) tree() *Tree {
 }
 
 func (word *WordNode) Copy() Node {
-	return &WordNode{NodeType: NodeWord, tr: word.tr, Word: append([]byte{}, word.Word...)}
+	return &WordNode{NodeType: NodeWord, tr: word.tr, Word: append([]byte{}, word.Word...), Quoted: word.Quoted}
 }
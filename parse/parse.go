@@ -1,8 +1,8 @@
 package parse
 
 import (
-	"fmt"
 	"runtime"
+	"strconv"
 )
 
 // https://golang.org/src/text/template/parse/parse.go
@@ -50,15 +50,6 @@ func New() *Tree {
 	return &Tree{}
 }
 
-func (t *Tree) errorf(format string, args ...interface{}) {
-	t.Root = nil
-	panic(fmt.Errorf(format, args...))
-}
-
-func (t *Tree) error(err error) {
-	t.errorf("%s", err)
-}
-
 func (t *Tree) expect(expected itemType, context string) item {
 	token := t.next()
 	if token.typ != expected {
@@ -75,8 +66,20 @@ func (t *Tree) expectOneOf(expected1, expected2 itemType, context string) item {
 	return token
 }
 
-func (t *Tree) unexpected(token item, context string) {
-	t.errorf("unexpected %s in %s", token, context)
+// abort the parse with an Error at token
+func (t *Tree) unexpected(token item, expected string) {
+	t.Root = nil
+
+	err := &Error{Query: t.query, Position: token.pos, Expected: expected}
+	switch token.typ {
+	case itemEOF:
+	case itemError:
+		err.Token = t.query[token.pos.Offset:]
+		err.Expected = token.val
+	default:
+		err.Token = token.val
+	}
+	panic(err)
 }
 
 func (t *Tree) recover(errp *error) {
@@ -121,40 +124,46 @@ func (t *Tree) parseCondBase(ender itemType) (cond *CondNode) {
 
 	for t.peek().typ != ender && t.peek().typ != itemEOF {
 		var next Node
-		if t.peek().typ == itemOpen {
+		switch t.peek().typ {
+		case itemOpen:
 			t.next()
 			newT := New()
 			newT.query = t.query
 			newT.startParse(t.lex)
 			next = newT.parseCond()
-		} else if t.peek().typ == itemLess {
+		case itemLess:
 			t.next()
 			next = t.parseLess()
-		} else if t.peek().typ == itemOr {
+		case itemOr:
 			t.next()
 			nextOr = true
 			continue
-		} else if t.peek().typ == itemWord {
+		case itemWord, itemQuoted:
 			next = t.parseWord()
-		}
-
-		if next != nil {
+		default:
 			if nextOr {
-				cond.or(next)
-				nextOr = false
-			} else {
-				cond.and(next)
+				t.unexpected(t.next(), "word or clause to or")
 			}
-		} else if nextOr {
-			t.errorf("expected word or clause to or")
+			t.unexpected(t.next(), "word or clause")
+		}
+
+		if nextOr {
+			cond.or(next)
+			nextOr = false
+		} else {
+			cond.and(next)
 		}
 	}
 
 	if nextOr {
-		t.errorf("expected word or clause to or (end reached)")
+		t.unexpected(t.next(), "word or clause to or")
 	}
 
-	t.next()
+	if ender == itemClose {
+		t.expect(ender, strconv.Quote(symbolClose)+" to close clause")
+	} else {
+		t.expect(ender, "end of query")
+	}
 	return cond
 }
 
@@ -163,17 +172,22 @@ func (t *Tree) parseCond() *CondNode {
 }
 
 func (t *Tree) parseLess() *LessNode {
-	token := t.expectOneOf(itemWord, itemOpen, "expected word or clause to negate")
-
-	if token.typ == itemWord {
+	token := t.next()
+	switch token.typ {
+	case itemWord, itemQuoted:
 		t.backup()
 		return t.newLess(t.parseWord())
+	case itemOpen:
+		return t.newLess(t.parseCond())
 	}
 
-	return t.newLess(t.parseCond())
+	t.unexpected(token, "word or clause to negate")
+	return nil
 }
 
 func (t *Tree) parseWord() *WordNode {
 	token := t.next()
-	return t.newWord(token.val)
+	word := t.newWord(token.val)
+	word.Quoted = token.typ == itemQuoted
+	return word
 }
@@ -0,0 +1,193 @@
+package booru
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// database statements
+const (
+	StatementAddPostPHash     = "alter table posts add column phash integer"
+	StatementQueryPostPHash   = "select phash from posts where id = ?"
+	StatementQueryPostPHashes = "select " + postColumns + " from posts where posts.phash is not null"
+)
+
+// errors
+var (
+	ErrorNoPerceptualHash = errors.New("post has no perceptual hash")
+)
+
+// A 64 bit perceptual hash, stored in the database as a (possibly negative)
+// integer; posts which aren't decodable images have no hash (NULL), scanned as 0.
+type PerceptualHash uint64
+
+func (h *PerceptualHash) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*h = 0
+	case int64:
+		*h = PerceptualHash(v)
+	default:
+		return fmt.Errorf("cannot scan %T into PerceptualHash", src)
+	}
+	return nil
+}
+
+// default maximum Hamming distance for similar: queries
+const defaultSimilarDistance = 8
+
+// Compute the difference hash of an image: the image is shrunk to 9x8 grey
+// pixels and each bit records whether a pixel is brighter than its right
+// neighbour. Resized and re-encoded copies of an image have hashes within a
+// small Hamming distance of each other.
+func DHash(img image.Image) (hash PerceptualHash) {
+	const w, h = 9, 8
+	var grey [h][w]float64
+
+	bounds := img.Bounds()
+	for y := 0; y < h; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/h
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/h
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < w; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/w
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/w
+			if x1 == x0 {
+				x1++
+			}
+
+			// average the luminance of the block
+			var sum float64
+			for py := y0; py < y1; py++ {
+				for px := x0; px < x1; px++ {
+					r, g, b, _ := img.At(px, py).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+				}
+			}
+			grey[y][x] = sum / float64((x1-x0)*(y1-y0))
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if grey[y][x] > grey[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return
+}
+
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Find the posts whose perceptual hash is within maxDistance of the post's,
+// including the post itself, nearest first.
+func (b *Booru) Similar(ctx context.Context, id int64, maxDistance int) (posts []Post, err error) {
+	var transaction *sql.Tx
+	if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer transaction.Rollback()
+
+	if posts, err = b.similar(ctx, transaction, id, maxDistance); err != nil {
+		return
+	}
+
+	if err = b.loadTags(ctx, transaction, posts); err != nil {
+		return
+	}
+
+	err = transaction.Commit()
+
+	return
+}
+
+func (b *Booru) similar(ctx context.Context, db querier, id int64, maxDistance int) (posts []Post, err error) {
+	var phash sql.NullInt64
+	err = db.QueryRowContext(ctx, StatementQueryPostPHash, id).Scan(&phash)
+	if err == sql.ErrNoRows {
+		err = ErrorInvalidPostID
+		return
+	} else if err != nil {
+		return
+	} else if !phash.Valid {
+		err = ErrorNoPerceptualHash
+		return
+	}
+
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, StatementQueryPostPHashes); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	distances := map[int64]int{}
+	for rows.Next() {
+		var post Post
+		if err = rows.Scan(post.fields()...); err != nil {
+			return
+		}
+
+		if distance := hammingDistance(uint64(phash.Int64), uint64(post.PHash)); distance <= maxDistance {
+			distances[post.ID] = distance
+			posts = append(posts, post)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	sort.SliceStable(posts, func(i, j int) bool {
+		if distances[posts[i].ID] != distances[posts[j].ID] {
+			return distances[posts[i].ID] < distances[posts[j].ID]
+		}
+		return ComparePostDescending(posts[i], posts[j]) == -1
+	})
+
+	return
+}
+
+// stream the posts similar to a post, given as "id" or "id:distance"
+func (b *Booru) similarStream(arg string, options queryOptions) CancelableStream {
+	step, options := options.step("Similar", arg)
+	return step.wrap(options.unseen(func(ctx context.Context) Stream {
+		idArg, distance := arg, defaultSimilarDistance
+		if i := strings.Index(arg, ":"); i != -1 {
+			var err error
+			if distance, err = strconv.Atoi(arg[i+1:]); err != nil {
+				return Failed(fmt.Errorf("similar:%s: %w", arg, err))(ctx)
+			}
+			idArg = arg[:i]
+		}
+
+		id, err := strconv.ParseInt(idArg, 10, 64)
+		if err != nil {
+			return Failed(fmt.Errorf("similar:%s: %w", arg, err))(ctx)
+		}
+
+		posts, err := b.similar(ctx, b.db, id, distance)
+		if err != nil {
+			return failedStream(ctx, fmt.Errorf("similar:%s: %w", arg, err))
+		}
+
+		sort.Slice(posts, func(i, j int) bool {
+			return options.order.Compare(posts[i], posts[j]) == -1
+		})
+
+		return sliceStream(ctx, posts)
+	}))
+}
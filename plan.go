@@ -0,0 +1,282 @@
+package booru
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dhlk/booru/parse"
+)
+
+// database statements
+const (
+	StatementCreateRelationsTagIndex = "create index relations_tag on relations (tag)"
+	StatementCountPosts              = "select count(*) from posts"
+	StatementCountTagPosts           = "select count(*) from relations join tags on relations.tag = tags.id where tags.tag = ?"
+)
+
+// estimates the number of posts matched by the words of a query
+type planner struct {
+	b      *Booru
+	db     querier
+	total  int64
+	counts map[string]int64
+}
+
+// Rewrite a parsed query into an equivalent one which is cheaper to stream:
+// nested clauses are flattened, duplicate terms dropped, the terms of each
+// intersection ordered from most to least selective, and clauses which must
+// be empty (a missing tag among their terms) cut down to that tag alone.
+func (b *Booru) plan(ctx context.Context, root *parse.CondNode) (plan *parse.CondNode, err error) {
+	p := planner{b: b, db: b.db, counts: map[string]int64{}}
+	if err = b.db.QueryRowContext(ctx, StatementCountPosts).Scan(&p.total); err != nil {
+		return
+	}
+
+	plan, _, err = p.cond(ctx, root)
+	return
+}
+
+// an estimate of the posts a node matches; 0 only when it certainly matches none
+func (p *planner) estimate(ctx context.Context, node parse.Node) (estimate int64, err error) {
+	switch n := node.(type) {
+	case *parse.CondNode:
+		_, estimate, err = p.cond(ctx, n)
+	case *parse.LessNode:
+		estimate = p.total
+	case *parse.WordNode:
+		estimate, err = p.word(ctx, n)
+	}
+	return
+}
+
+func (p *planner) word(ctx context.Context, node *parse.WordNode) (estimate int64, err error) {
+	key := parse.Format(node)
+	if estimate, ok := p.counts[key]; ok {
+		return estimate, nil
+	}
+	defer func() { p.counts[key] = estimate }()
+
+	word := string(node.Word)
+
+	// an invalid rate fails here, as it would when streamed, rather than
+	// being planned away as matching nothing
+	if !node.Quoted && strings.HasPrefix(word, "random:") {
+		rate := strings.TrimPrefix(word, "random:")
+		var r float64
+		if r, err = strconv.ParseFloat(rate, 64); err != nil {
+			err = fmt.Errorf("random:%s: %w", rate, err)
+			return
+		}
+		if r <= 0 {
+			return 0, nil
+		}
+		return int64(float64(p.total)*r) + 1, nil
+	}
+
+	// only plain tags are counted; anything else may match every post
+	if !plainTag(node) {
+		return p.total, nil
+	}
+
+	var canonical string
+	if canonical, err = canonicalTag(ctx, p.db, word); err != nil {
+		return
+	}
+
+	var implying []string
+	if implying, err = closure(ctx, p.db, StatementQueryImpliedBy, canonical); err != nil {
+		return
+	}
+
+	for _, tag := range append([]string{canonical}, implying...) {
+		var count int64
+		if err = p.db.QueryRowContext(ctx, StatementCountTagPosts, tag).Scan(&count); err != nil {
+			return
+		}
+		estimate += count
+	}
+
+	if estimate > p.total {
+		estimate = p.total
+	}
+
+	return
+}
+
+// whether a word is a tag, rather than a word with a meaning of its own such
+// as date:, namespace:* or a glob
+func isPlainTag(word string) bool {
+	namespace, _ := SplitTag(word)
+	return !IsReservedNamespace(namespace) && !strings.HasSuffix(word, ":*") && !IsGlob(word)
+}
+
+// whether a query word is a tag; a quoted word always is
+func plainTag(word *parse.WordNode) bool {
+	return word.Quoted || isPlainTag(string(word.Word))
+}
+
+// A query word matching exactly the posts tagged tag, quoted when the tag
+// would otherwise mean something else (such as date:2020 or what?).
+func TagQuery(tag string) string {
+	return parse.Format(&parse.WordNode{NodeType: parse.NodeWord, Word: []byte(tag), Quoted: !isPlainTag(tag)})
+}
+
+type plannedNode struct {
+	node     parse.Node
+	estimate int64
+}
+
+func (p *planner) cond(ctx context.Context, cond *parse.CondNode) (plan *parse.CondNode, estimate int64, err error) {
+	var and, or, less []parse.Node
+	if and, or, less, err = p.flatten(ctx, cond); err != nil {
+		return
+	}
+
+	plan = &parse.CondNode{NodeType: parse.NodeCond}
+
+	var positive []plannedNode
+	for _, node := range and {
+		var e int64
+		if e, err = p.estimate(ctx, node); err != nil {
+			return
+		}
+		if e == 0 {
+			plan.And = []parse.Node{node}
+			return plan, 0, nil
+		}
+		positive = append(positive, plannedNode{node, e})
+	}
+
+	var orEstimate int64
+	var empty parse.Node
+	for _, node := range or {
+		var e int64
+		if e, err = p.estimate(ctx, node); err != nil {
+			return
+		}
+		if e == 0 {
+			empty = node
+			continue
+		}
+		plan.Or = append(plan.Or, node)
+		orEstimate += e
+	}
+	if len(or) > 0 && len(plan.Or) == 0 {
+		plan.And, plan.Or = []parse.Node{empty}, nil
+		return plan, 0, nil
+	}
+
+	for _, node := range less {
+		var e int64
+		if e, err = p.estimate(ctx, node.(*parse.LessNode).Less); err != nil {
+			return
+		}
+		// nothing to take away
+		if e == 0 {
+			continue
+		}
+		plan.And = append(plan.And, node)
+	}
+
+	sort.SliceStable(positive, func(i, j int) bool {
+		return positive[i].estimate < positive[j].estimate
+	})
+
+	estimate = p.total
+	if len(plan.Or) > 0 && orEstimate < estimate {
+		estimate = orEstimate
+	}
+	nodes := make([]parse.Node, len(positive))
+	for i, planned := range positive {
+		nodes[i] = planned.node
+		if planned.estimate < estimate {
+			estimate = planned.estimate
+		}
+	}
+	plan.And = append(nodes, plan.And...)
+
+	return
+}
+
+// plan the terms of a clause, splicing in nested clauses where that doesn't
+// change the result and removing duplicates; negated terms are returned apart
+func (p *planner) flatten(ctx context.Context, cond *parse.CondNode) (and, or, less []parse.Node, err error) {
+	seen := map[string]bool{}
+	add := func(list *[]parse.Node, node parse.Node, key string) {
+		if key = key + parse.Format(node); !seen[key] {
+			seen[key] = true
+			*list = append(*list, node)
+		}
+	}
+
+	var lifted bool
+	for _, node := range cond.And {
+		if node, err = p.node(ctx, node); err != nil {
+			return
+		}
+
+		switch n := node.(type) {
+		case *parse.CondNode:
+			for _, child := range n.And {
+				if _, ok := child.(*parse.LessNode); ok {
+					add(&less, child, "&")
+				} else {
+					add(&and, child, "&")
+				}
+			}
+			// a union can only be taken up once
+			if len(n.Or) > 0 && len(cond.Or) == 0 && !lifted {
+				lifted = true
+				for _, child := range n.Or {
+					add(&or, child, "|")
+				}
+			} else if len(n.Or) > 0 {
+				add(&and, &parse.CondNode{NodeType: parse.NodeCond, Or: n.Or}, "&")
+			}
+		case *parse.LessNode:
+			add(&less, n, "&")
+		default:
+			add(&and, n, "&")
+		}
+	}
+
+	for _, node := range cond.Or {
+		if node, err = p.node(ctx, node); err != nil {
+			return
+		}
+
+		if n, ok := node.(*parse.CondNode); ok && len(n.And) == 0 && len(n.Or) > 0 {
+			for _, child := range n.Or {
+				add(&or, child, "|")
+			}
+		} else if ok && len(n.And) == 1 && len(n.Or) == 0 {
+			add(&or, n.And[0], "|")
+		} else {
+			add(&or, node, "|")
+		}
+	}
+
+	return
+}
+
+// plan the clauses within a node
+func (p *planner) node(ctx context.Context, node parse.Node) (planned parse.Node, err error) {
+	switch n := node.(type) {
+	case *parse.CondNode:
+		planned, _, err = p.cond(ctx, n)
+	case *parse.LessNode:
+		var less parse.Node
+		if less, err = p.node(ctx, n.Less); err != nil {
+			return
+		}
+		copied := n.Copy().(*parse.LessNode)
+		copied.Less = less
+		planned = copied
+	default:
+		planned = node
+	}
+	return
+}
@@ -0,0 +1,125 @@
+package booru
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/dhlk/booru/parse"
+)
+
+func TestPlan(t *testing.T) {
+	ctx := context.Background()
+
+	// a on 10 posts, b on 7 and c on 4
+	b := testBooru(t)
+	seedPosts(t, b, 20, func(i int) (tags []string) {
+		if i%2 == 0 {
+			tags = append(tags, "a")
+		}
+		if i%3 == 0 {
+			tags = append(tags, "b")
+		}
+		if i%5 == 0 {
+			tags = append(tags, "c")
+		}
+		return
+	})
+
+	tests := []struct {
+		query, plan string
+	}{
+		// most selective first
+		{"a b c", "c b a"},
+		// flattening
+		{"a (( b c ))", "c b a"},
+		{"(( a ~b )) ~c", "(( ~b )) a ~c"},
+		{"(( a ~b )) -c", "a -c ~b"},
+		{"a ~(( b c ))", "a ~(( c b ))"},
+		// duplicates
+		{"a a b", "b a"},
+		{"a b a", "b a"},
+		{"~a ~b ~a", "~a ~b"},
+		{"-a -a", "-a"},
+		// an unknown tag
+		{"a missing b", "missing"},
+		{"~a ~missing", "~a"},
+		{"~missing ~nothing", "nothing"},
+		{"a -missing", "a"},
+		{"-missing", ""},
+		// left as they are
+		{"a -b", "a -b"},
+		{"-(( -a ))", "-(( -a ))"},
+		{"a*", "a*"},
+	}
+
+	for _, test := range tests {
+		tree, err := parse.Parse(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// evaluated as written
+		var want []int64
+		stream := b.queryForNode(tree.Root, queryOptions{order: OrderDescending})(ctx)
+		for post := range stream.C {
+			want = append(want, post.ID)
+		}
+		if err = stream.Err(); err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+
+		plan, err := b.plan(ctx, tree.Root.(*parse.CondNode))
+		if err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+		if got := parse.Format(plan); got != test.plan {
+			t.Errorf("%s: got plan %q, want %q", test.query, got, test.plan)
+		}
+
+		explained, err := b.Explain(ctx, test.query)
+		if err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+		if explained.Operator != "Query" || explained.Argument != test.plan {
+			t.Errorf("%s: explained as %s %q, want Query %q", test.query, explained.Operator, explained.Argument, test.plan)
+		}
+
+		if got := allPages(t, b, test.query, 3); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v as written", test.query, got, want)
+		}
+	}
+}
+
+// a -b takes b from the posts tagged a, rather than from every post
+func TestPlanDifference(t *testing.T) {
+	ctx := context.Background()
+
+	b := testBooru(t)
+	seedPosts(t, b, 10, func(i int) []string { return []string{"a", "b"}[:i%3] })
+
+	everything := func(query string) (scanned bool) {
+		plan, err := b.Explain(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var walk func(step *Step)
+		walk = func(step *Step) {
+			scanned = scanned || step.Operator == "Index" && step.Argument == "everything"
+			for _, child := range step.Steps {
+				walk(child)
+			}
+		}
+		walk(plan)
+
+		return
+	}
+
+	if everything("a -b") {
+		t.Errorf("a -b: read every post")
+	}
+	if !everything("-b") {
+		t.Errorf("-b: didn't read every post")
+	}
+}
@@ -3,8 +3,8 @@ package booru
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -12,50 +12,165 @@ import (
 	"github.com/dhlk/booru/parse"
 )
 
-var nothing CancelableStream = func(context.Context) <-chan Post {
-	c := make(chan Post)
-	close(c)
-	return c
+// state shared by every node of a query
+type queryOptions struct {
+	order   Order
+	after   *Post // the last post of the page before, if any
+	explain *Step // the step being built, when explaining
 }
 
-var compare = ComparePostDescending
+// parse and plan a top level query, taking its order from an order: word and
+// starting after a cursor
+func (b *Booru) query(ctx context.Context, query, cursor string, options queryOptions) (result CancelableStream, order Order, err error) {
+	var tree *parse.Tree
+	if tree, err = parse.Parse(query); err != nil {
+		return
+	}
+
+	if options.order, err = queryOrder(tree.Root); err != nil {
+		return
+	}
+	if options.after, err = decodeCursor(cursor, options.order); err != nil {
+		return
+	}
+
+	result, err = b.planQuery(ctx, tree.Root, options)
+	return result, options.order, err
+}
 
-func (b *Booru) query(query string) (result CancelableStream, err error) {
-	// parse the query
+// parse and plan a subquery, which is evaluated in the order of the
+// enclosing query
+func (b *Booru) subquery(ctx context.Context, query string, options queryOptions) (result CancelableStream, err error) {
 	var tree *parse.Tree
 	if tree, err = parse.Parse(query); err != nil {
-		log.Printf("%v", err)
 		return
 	}
 
-	result = b.queryForNode(tree.Root)
+	if _, err = queryOrder(tree.Root); err != nil {
+		return
+	}
+
+	return b.planQuery(ctx, tree.Root, options)
+}
+
+func (b *Booru) planQuery(ctx context.Context, root parse.Node, options queryOptions) (result CancelableStream, err error) {
+	var plan *parse.CondNode
+	if plan, err = b.plan(ctx, root.(*parse.CondNode)); err != nil {
+		return
+	}
+
+	step, options := options.step("Query", parse.Format(plan))
+	result = step.wrap(b.queryForNode(plan, options))
 	return
 }
 
-func (b *Booru) Query(ctx context.Context, query string, page, length int64) (posts []Post, err error) {
+// stream a subquery, generating its text when the stream is opened
+func (b *Booru) subqueryStream(operator, argument string, generate func(context.Context) (string, error), options queryOptions) CancelableStream {
+	step, options := options.step(operator, argument)
+	return step.wrap(func(ctx context.Context) Stream {
+		query, err := generate(ctx)
+		if err == ErrorNoMatchingTags {
+			// a pattern matching no tags matches no posts
+			return nothing(ctx)
+		} else if err != nil {
+			return failedStream(ctx, fmt.Errorf("%s %q: %w", operator, argument, err))
+		}
+
+		result, err := b.subquery(ctx, query, options)
+		if err != nil {
+			return failedStream(ctx, fmt.Errorf("%s %q: %w", operator, argument, err))
+		}
+		return result(ctx)
+	})
+}
+
+// remove any order: words from the top level of a query, returning the last
+func queryOrder(root parse.Node) (order Order, err error) {
+	order = OrderDescending
+
+	cond, ok := root.(*parse.CondNode)
+	if !ok {
+		return
+	}
+
+	and := cond.And[:0]
+	for _, node := range cond.And {
+		word, ok := node.(*parse.WordNode)
+		if !ok || word.Quoted || !strings.HasPrefix(string(word.Word), "order:") {
+			and = append(and, node)
+			continue
+		}
+
+		if order, err = ParseOrder(strings.TrimPrefix(string(word.Word), "order:")); err != nil {
+			return
+		}
+	}
+	cond.And = and
+
+	err = nestedOrder(cond)
+
+	return
+}
+
+// fails with ErrorInvalidOrder on an order: word left in a query, which can
+// only order the whole query from its top level
+func nestedOrder(node parse.Node) error {
+	switch node := node.(type) {
+	case *parse.CondNode:
+		for _, nodes := range [][]parse.Node{node.And, node.Or} {
+			for _, node := range nodes {
+				if err := nestedOrder(node); err != nil {
+					return err
+				}
+			}
+		}
+	case *parse.LessNode:
+		return nestedOrder(node.Less)
+	case *parse.WordNode:
+		if !node.Quoted && strings.HasPrefix(string(node.Word), "order:") {
+			return fmt.Errorf("%s: %w", node.Word, ErrorInvalidOrder)
+		}
+	}
+	return nil
+}
+
+// Get up to length results of a query, starting after cursor (or from the
+// first result when cursor is empty). next is the cursor of the following
+// page, or empty on the last page.
+func (b *Booru) Query(ctx context.Context, query, cursor string, length int64) (posts []Post, next string, err error) {
 	fwdCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	var results CancelableStream
-	if results, err = b.query(query); err != nil {
+	var order Order
+	if results, order, err = b.query(ctx, query, cursor, queryOptions{}); err != nil {
 		return
 	}
-	selection := Limit(Skip(results, page*length), length)(fwdCtx)
+
+	// one more than asked for shows whether there is another page
+	selection := Limit(results, length+1)(fwdCtx)
+	for post := range selection.C {
+		posts = append(posts, post)
+	}
+	if err = selection.Err(); err != nil {
+		return nil, "", err
+	}
+	if int64(len(posts)) > length {
+		posts = posts[:length]
+		if length > 0 {
+			next = encodeCursor(order, posts[length-1])
+		}
+	}
 
 	// open the transaction and add tag data
 	var transaction *sql.Tx
 	if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
-		log.Printf("%v", err)
 		return
 	}
 	defer transaction.Rollback()
 
-	for post := range selection {
-		if post.Tags, err = b.GetPostTags(ctx, transaction, post.ID); err != nil {
-			log.Printf("%v", err)
-			return
-		}
-		posts = append(posts, post)
+	if err = b.loadTags(ctx, transaction, posts); err != nil {
+		return
 	}
 	err = transaction.Commit()
 
@@ -68,95 +183,118 @@ func (b *Booru) Count(ctx context.Context, query string) (count int64, err error
 	count = 0
 
 	var results CancelableStream
-	if results, err = b.query(query); err != nil {
+	if results, _, err = b.query(ctx, query, "", queryOptions{}); err != nil {
 		return
 	}
 
-	for range results(fwdCtx) {
+	stream := results(fwdCtx)
+	for range stream.C {
 		count++
 	}
+	err = stream.Err()
 
 	return
 }
 
-func (b *Booru) queryForNode(node parse.Node) CancelableStream {
+func (b *Booru) queryForNode(node parse.Node, options queryOptions) CancelableStream {
+	if b.memory != nil && memoryEvaluable(node) {
+		return b.memoryStream(node, options)
+	}
+
 	switch node.Type() {
 	case parse.NodeCond:
-		return b.queryConditionalNode(node.(*parse.CondNode))
+		return b.queryConditionalNode(node.(*parse.CondNode), options)
 	case parse.NodeLess:
-		return b.queryLessNode(node.(*parse.LessNode))
+		return b.queryLessNode(node.(*parse.LessNode), options)
 	case parse.NodeWord:
-		return b.queryWordNode(node.(*parse.WordNode))
+		return b.queryWordNode(node.(*parse.WordNode), options)
 	}
 
 	// should be unreachable
 	panic(nil)
 }
 
-func (b *Booru) queryConditionalNode(cond *parse.CondNode) CancelableStream {
-	orArr := make([]CancelableStream, len(cond.Or))
-	for i, or := range cond.Or {
-		orArr[i] = b.queryForNode(or)
+// an intersection of the positive terms (and the union of the or terms), less
+// the union of the negated ones; with no positive terms, everything is the
+// space negated terms are taken from
+func (b *Booru) queryConditionalNode(cond *parse.CondNode, options queryOptions) CancelableStream {
+	if b.memory != nil {
+		cond = gatherMemoryTerms(cond)
+	}
+
+	var positive, negative []parse.Node
+	for _, and := range cond.And {
+		if less, ok := and.(*parse.LessNode); ok {
+			negative = append(negative, less.Less)
+		} else {
+			positive = append(positive, and)
+		}
+	}
+
+	var complementStep *Step
+	spaceOptions := options
+	if len(negative) > 0 {
+		complementStep, spaceOptions = options.step("Complement", "")
 	}
 
-	andArr := make([]CancelableStream, len(cond.And))
-	for i, and := range cond.And {
-		andArr[i] = b.queryForNode(and)
+	var result CancelableStream
+	if len(positive)+len(cond.Or) == 0 {
+		result = b.queryEveryPost(spaceOptions)
+	} else {
+		step, andOptions := spaceOptions.step("Intersection", "")
+		andArr := make([]CancelableStream, len(positive))
+		for i, and := range positive {
+			andArr[i] = b.queryForNode(and, andOptions)
+		}
+		if len(cond.Or) > 0 {
+			andArr = append(andArr, b.queryUnion(cond.Or, andOptions))
+		}
+		result = step.wrap(Intersection(andArr, options.order.Compare))
 	}
-	if len(orArr) > 0 {
-		andArr = append(andArr, Union(orArr, compare))
+
+	if len(negative) > 0 {
+		result = complementStep.wrap(Complement(b.queryUnion(negative, spaceOptions), result, options.order.Compare))
 	}
+	return result
+}
 
-	if len(andArr) == 0 {
-		return b.queryEveryPost()
+func (b *Booru) queryUnion(nodes []parse.Node, options queryOptions) CancelableStream {
+	step, options := options.step("Union", "")
+	streams := make([]CancelableStream, len(nodes))
+	for i, node := range nodes {
+		streams[i] = b.queryForNode(node, options)
 	}
-	return Intersection(andArr, compare)
+	return step.wrap(Union(streams, options.order.Compare))
 }
 
-func (b *Booru) queryLessNode(less *parse.LessNode) CancelableStream {
-	return Complement(b.queryForNode(less.Less), b.queryEveryPost(), compare)
+func (b *Booru) queryLessNode(less *parse.LessNode, options queryOptions) CancelableStream {
+	step, options := options.step("Complement", "")
+	return step.wrap(Complement(b.queryForNode(less.Less, options), b.queryEveryPost(options), options.order.Compare))
 }
 
-func (b *Booru) queryWordNode(word *parse.WordNode) CancelableStream {
+func (b *Booru) queryWordNode(word *parse.WordNode, options queryOptions) CancelableStream {
 	tag := string(word.Word)
 
+	// a quoted word is a tag, whatever it looks like
+	if word.Quoted {
+		return b.ordered(b.tagStream, tag, options)
+	}
+
 	// load baseline subquery
 	if strings.HasPrefix(tag, "baseline:") {
 		baseline := strings.Replace(tag, "baseline:", "", -1)
-		query, err := ioutil.ReadFile(filepath.Join(b.baseline, baseline))
-		if err != nil {
-			log.Printf("%v", err)
-			return nothing
-		}
-
-		result, err := b.query(string(query))
-		if err != nil {
-			log.Printf("%v", err)
-			return nothing
-		}
-		return result
+		return b.subqueryStream("Baseline", baseline, func(context.Context) (string, error) {
+			query, err := ioutil.ReadFile(filepath.Join(b.baseline, baseline))
+			return string(query), err
+		}, options)
 	}
 
 	// load regex subquery
 	if strings.HasPrefix(tag, "regex:") {
-		if err := b.GenerateTagIndex(context.TODO()); err != nil {
-			log.Printf("%v", err)
-			return nothing
-		}
-
 		regex := strings.Replace(tag, "regex:", "", -1)
-		query, err := b.generateTagQuery(context.TODO(), regex)
-		if err != nil {
-			log.Printf("%v", err)
-			return nothing
-		}
-
-		result, err := b.query(string(query))
-		if err != nil {
-			log.Printf("%v", err)
-			return nothing
-		}
-		return result
+		return b.subqueryStream("Regex", regex, func(ctx context.Context) (string, error) {
+			return b.generateTagQuery(ctx, regex)
+		}, options)
 	}
 
 	// load random subquery
@@ -164,16 +302,86 @@ func (b *Booru) queryWordNode(word *parse.WordNode) CancelableStream {
 		rate := strings.Replace(tag, "random:", "", -1)
 		r, err := strconv.ParseFloat(rate, 64)
 		if err != nil {
-			log.Printf("%v", err)
-			return nothing
+			return Failed(fmt.Errorf("random:%s: %w", rate, err))
 		}
 
-		return Random(b.queryEveryPost(), r)
+		step, options := options.step("Random", rate)
+		return step.wrap(Random(b.queryEveryPost(options), r))
 	}
 
-	return b.indexStreamCancelable(string(word.Word))
+	// load time range subqueries
+	if strings.HasPrefix(tag, "date:") {
+		return b.ordered(b.dateStream, strings.TrimPrefix(tag, "date:"), options)
+	}
+	if strings.HasPrefix(tag, "age:") {
+		return b.ordered(b.ageStream, strings.TrimPrefix(tag, "age:"), options)
+	}
+
+	// load similar image subquery
+	if strings.HasPrefix(tag, "similar:") {
+		return b.similarStream(strings.TrimPrefix(tag, "similar:"), options)
+	}
+
+	// every tag in a namespace
+	if strings.HasSuffix(tag, ":*") {
+		return b.ordered(b.namespaceStream, strings.TrimSuffix(tag, ":*"), options)
+	}
+
+	// every tag matching a wildcard pattern
+	if IsGlob(tag) {
+		return b.ordered(b.globStream, tag, options)
+	}
+
+	return b.ordered(b.tagStream, tag, options)
+}
+
+// build a stream read from the indexes, and put it into the order of the query
+func (b *Booru) ordered(build func(string, queryOptions) CancelableStream, arg string, options queryOptions) CancelableStream {
+	if options.order.indexed() {
+		return build(arg, options)
+	}
+
+	step, options := options.step("Sorted", options.order.Name)
+	return step.wrap(options.unseen(Sorted(build(arg, options), options.order.Compare)))
+}
+
+// scan the index of a tag
+func (b *Booru) indexScan(tag string, options queryOptions) CancelableStream {
+	argument := tag
+	if tag == globalIndexTag {
+		argument = "everything"
+	}
+
+	step, _ := options.step("Index", argument)
+	return step.wrap(func(ctx context.Context) Stream {
+		return b.indexStreamFrom(ctx, tag, options.indexSeen())
+	})
+}
+
+// stream the posts of a tag, resolving aliases and including every tag which
+// implies it
+func (b *Booru) tagStream(tag string, options queryOptions) CancelableStream {
+	step, options := options.step("Tag", tag)
+	return step.wrap(func(ctx context.Context) Stream {
+		canonical, err := canonicalTag(ctx, b.db, tag)
+		if err != nil {
+			return failedStream(ctx, err)
+		}
+
+		implying, err := closure(ctx, b.db, StatementQueryImpliedBy, canonical)
+		if err != nil {
+			return failedStream(ctx, err)
+		}
+
+		streams := []CancelableStream{b.indexScan(canonical, options)}
+		for _, t := range implying {
+			streams = append(streams, b.indexScan(t, options))
+		}
+
+		return union(ctx, streams, OrderDescending.Compare)
+	})
 }
 
-func (b *Booru) queryEveryPost() CancelableStream {
-	return b.indexStreamCancelable(globalIndexTag)
+func (b *Booru) queryEveryPost(options queryOptions) CancelableStream {
+	return b.ordered(b.indexScan, globalIndexTag, options)
 }
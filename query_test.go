@@ -0,0 +1,108 @@
+package booru
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// tags from before their namespace was reserved, or which look like globs,
+// are still found when quoted
+func TestQueryQuoted(t *testing.T) {
+	ctx := context.Background()
+
+	b := testBooru(t)
+	ids := seedPosts(t, b, 20, func(i int) (tags []string) {
+		if i%2 == 0 {
+			tags = append(tags, "what?")
+		}
+		if i%3 == 0 {
+			tags = append(tags, "whatever")
+		}
+		return
+	})
+
+	// a tag in a namespace reserved since it was added
+	result, err := b.db.ExecContext(ctx, StatementInsertTag, "date:2020", "date")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids[:5] {
+		if _, err = b.db.ExecContext(ctx, StatementInsertRelation, id, tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = b.clearIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if reserved, err := b.ReservedTags(ctx); err != nil || !reflect.DeepEqual(reserved, []string{"date:2020"}) {
+		t.Errorf("reserved tags: got %v, %v", reserved, err)
+	}
+
+	tagged := func(tag string) (want []int64) {
+		for i := range ids {
+			switch {
+			case tag == "what?" && i%2 == 0,
+				tag == "whatever" && i%3 == 0,
+				tag == "date:2020" && i < 5:
+				want = append(want, ids[i])
+			}
+		}
+		return
+	}
+
+	for _, memory := range []bool{false, true} {
+		if memory {
+			if err = b.LoadMemoryIndex(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, tag := range []string{"what?", "whatever", "date:2020"} {
+			query := TagQuery(tag)
+			got := allPages(t, b, query, 7)
+			sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+			if !reflect.DeepEqual(got, tagged(tag)) {
+				t.Errorf("memory %v: %s: got %v, want %v", memory, query, got, tagged(tag))
+			}
+		}
+
+		// unquoted, they mean something else
+		if got := allPages(t, b, "what*", 100); len(got) != 13 {
+			t.Errorf("memory %v: what* as a glob: got %d posts, want 13", memory, len(got))
+		}
+		if got := allPages(t, b, `"order:asc"`, 100); len(got) != 0 {
+			t.Errorf(`memory %v: "order:asc": got %d posts, want none`, memory, len(got))
+		}
+	}
+}
+
+// order: orders the whole query, so it is only taken at the top level
+func TestQueryNestedOrder(t *testing.T) {
+	ctx := context.Background()
+
+	b := testBooru(t)
+	seedPosts(t, b, 5, func(i int) []string { return []string{"a"} })
+
+	for _, query := range []string{
+		"(( a order:asc ))",
+		"a ~order:asc ~b",
+		"a -order:asc",
+		"-(( order:id ))",
+	} {
+		if _, _, err := b.Query(ctx, query, "", 10); !errors.Is(err, ErrorInvalidOrder) {
+			t.Errorf("%s: got %v, want %v", query, err, ErrorInvalidOrder)
+		}
+	}
+
+	if got := allPages(t, b, "a order:asc", 2); len(got) != 5 {
+		t.Errorf("a order:asc: got %d posts, want 5", len(got))
+	}
+}
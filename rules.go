@@ -0,0 +1,225 @@
+package booru
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+)
+
+// database statements
+const (
+	StatementCreateRules = "create table rules (id integer not null primary key autoincrement, pattern text not null, tags text not null)"
+	StatementQueryRules  = "select id, pattern, tags from rules order by id"
+	StatementInsertRule  = "insert into rules (pattern, tags) values (?, ?)"
+	StatementDeleteRule  = "delete from rules where id = ?"
+)
+
+// A Rule tags posts whose path matches Pattern. Each of Tags is a template
+// expanded with the pattern's capture groups ($1, ${name}), with whitespace in
+// the result replaced by underscores; templates which expand to nothing are
+// dropped.
+type Rule struct {
+	ID      int64
+	Pattern string
+	Tags    []string
+}
+
+// The tags a rule gives to posts which were, or would have been, tagged by it.
+type RuleChange struct {
+	Post Post
+	Add  []string
+}
+
+type compiledRule struct {
+	Rule
+	regex *regexp.Regexp
+}
+
+type ruleSet []compiledRule
+
+// Add a rule, applied after every existing rule. Fails with
+// ErrorReservedNamespace if a tag template is in a reserved namespace.
+func (b *Booru) AddRule(ctx context.Context, pattern string, tags ...string) (id int64, err error) {
+	if _, err = regexp.Compile(pattern); err != nil {
+		return
+	}
+	for _, template := range tags {
+		if namespace, _ := SplitTag(template); IsReservedNamespace(namespace) {
+			err = ErrorReservedNamespace
+			return
+		}
+	}
+
+	var result sql.Result
+	if result, err = b.db.ExecContext(ctx, StatementInsertRule, pattern, strings.Join(tags, " ")); err != nil {
+		return
+	}
+
+	return result.LastInsertId()
+}
+
+func (b *Booru) RemoveRule(ctx context.Context, id int64) (err error) {
+	_, err = b.db.ExecContext(ctx, StatementDeleteRule, id)
+	return
+}
+
+// Get every rule, in the order they are applied.
+func (b *Booru) Rules(ctx context.Context) (rules []Rule, err error) {
+	return queryRules(ctx, b.db)
+}
+
+func queryRules(ctx context.Context, db querier) (rules []Rule, err error) {
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, StatementQueryRules); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rule Rule
+		var tags string
+		if err = rows.Scan(&rule.ID, &rule.Pattern, &tags); err != nil {
+			return
+		}
+		rule.Tags = strings.Fields(tags)
+		rules = append(rules, rule)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+func (b *Booru) ruleSet(ctx context.Context) (rules ruleSet, err error) {
+	var stored []Rule
+	if stored, err = b.Rules(ctx); err != nil {
+		return
+	}
+
+	for _, rule := range stored {
+		var regex *regexp.Regexp
+		if regex, err = regexp.Compile(rule.Pattern); err != nil {
+			return
+		}
+		rules = append(rules, compiledRule{rule, regex})
+	}
+
+	return
+}
+
+// the tags every matching rule gives a path, without duplicates
+func (rules ruleSet) tags(path string) (tags []string) {
+	seen := map[string]bool{}
+
+	for _, rule := range rules {
+		match := rule.regex.FindStringSubmatchIndex(path)
+		if match == nil {
+			continue
+		}
+
+		for _, template := range rule.Tags {
+			tag := string(rule.regex.ExpandString(nil, template, path, match))
+			tag = strings.Join(strings.Fields(tag), "_")
+			// a capture may expand into a reserved namespace, which can't be
+			// tagged; one bad rule shouldn't stop an import
+			if namespace, _ := SplitTag(tag); tag == "" || seen[tag] || IsReservedNamespace(namespace) {
+				continue
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	return
+}
+
+// Apply every rule to every post, returning the tags added to each post. With
+// dryRun nothing is changed, so the result is what would be added.
+func (b *Booru) ReapplyRules(ctx context.Context, dryRun bool) (changes []RuleChange, err error) {
+	var rules ruleSet
+	if rules, err = b.ruleSet(ctx); err != nil {
+		return
+	}
+
+	var transaction *sql.Tx
+	if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer transaction.Rollback()
+
+	var posts []Post
+	if posts, err = scanPosts(ctx, transaction, StatementQueryEveryPostPath); err != nil {
+		return
+	}
+	if err = b.loadTags(ctx, transaction, posts); err != nil {
+		return
+	}
+
+	var stale []string
+	var writes []memoryWrite
+	for _, post := range posts {
+		has := map[string]bool{}
+		for _, tag := range post.Tags {
+			has[tag.Tag] = true
+		}
+
+		change := RuleChange{Post: post}
+		for _, tag := range rules.tags(post.Post) {
+			var canonical string
+			if canonical, err = canonicalTag(ctx, transaction, tag); err != nil {
+				return
+			}
+			if has[canonical] {
+				continue
+			}
+			has[canonical] = true
+
+			if !dryRun {
+				if _, err = b.tagPost(ctx, transaction, post.ID, canonical); err != nil {
+					return
+				}
+				stale = append(stale, canonical)
+			}
+			change.Add = append(change.Add, canonical)
+		}
+
+		if len(change.Add) > 0 {
+			changes = append(changes, change)
+			writes = append(writes, memoryWrite{id: post.ID, tagged: change.Add})
+		}
+	}
+
+	if dryRun {
+		return
+	}
+
+	if err = transaction.Commit(); err != nil {
+		return
+	}
+
+	b.memory.apply(writes...)
+	err = b.invalidateIndexes(stale...)
+
+	return
+}
+
+func scanPosts(ctx context.Context, db querier, statement string, args ...interface{}) (posts []Post, err error) {
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, statement, args...); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var post Post
+		if err = rows.Scan(post.fields()...); err != nil {
+			return
+		}
+		posts = append(posts, post)
+	}
+
+	err = rows.Err()
+
+	return
+}
@@ -0,0 +1,51 @@
+package booru
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRulesReservedNamespace(t *testing.T) {
+	ctx := context.Background()
+	b := testBooru(t)
+
+	if _, err := b.AddRule(ctx, `/(\d{4})/`, "date:$1"); err != ErrorReservedNamespace {
+		t.Errorf("reserved template: got %v, want %v", err, ErrorReservedNamespace)
+	}
+
+	// a capture which expands into a reserved namespace is skipped
+	if _, err := b.AddRule(ctx, `/([a-z]+:\d{4})/`, "$1", "year"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"date:2020/a.png", "date:2020/b.png", "series:2021/c.png"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := b.Scan(ctx, []string{dir}, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Added != 3 {
+		t.Errorf("scan added %d posts, want 3", report.Added)
+	}
+
+	for query, want := range map[string]int{"year": 3, "series:2021": 1, `"date:2020"`: 0} {
+		if got := allPages(t, b, query, 10); len(got) != want {
+			t.Errorf("%s: got %d posts, want %d", query, len(got), want)
+		}
+	}
+
+	if _, err = b.ReapplyRules(ctx, false); err != nil {
+		t.Errorf("reapplying rules: %v", err)
+	}
+}
@@ -0,0 +1,412 @@
+package booru
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// database statements
+const (
+	StatementQueryPaths         = "select post from posts union select location from locations"
+	StatementQueryEveryPostPath = "select " + postColumns + " from posts order by posts.post"
+)
+
+// posts added per transaction when ScanOptions.BatchSize is unset
+const DefaultScanBatchSize = 500
+
+type ScanOptions struct {
+	BatchSize int             // posts added per transaction
+	Policy    DuplicatePolicy // how to add files whose contents are already posts
+	Progress  func(ScanProgress)
+}
+
+// Progress of a scan, reported after each file.
+type ScanProgress struct {
+	Path      string
+	Scanned   int64 // files seen
+	Added     int64 // new posts and locations
+	Merged    int64 // duplicates whose tags went to the existing post
+	Refreshed int64 // posts whose files changed, and whose metadata was read again
+	Skipped   int64 // unchanged known paths and rejected duplicates
+}
+
+type ScanReport struct {
+	ScanProgress
+	Missing []Post  // posts under the roots whose files have disappeared
+	Errors  []error // sidecars which could not be read; their posts were added with the tags of the rest
+}
+
+// Walk the roots, adding every file not yet in the booru as a post tagged by
+// the booru's rules and its sidecars. A post's time is when it was taken
+// according to its exif data, or else its mtime. Sidecars and hidden files and
+// directories are skipped. The metadata of a known post is read again when its
+// file's size differs from the recorded one (RefreshMetadata rereads every
+// file). Posts are committed in batches; on error or cancellation the batches
+// already committed stay in the booru.
+func (b *Booru) Scan(ctx context.Context, roots []string, options ScanOptions) (report ScanReport, err error) {
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultScanBatchSize
+	}
+
+	var known map[string]bool
+	if known, err = b.knownPaths(ctx); err != nil {
+		return
+	}
+
+	var posts map[string]Post
+	if posts, err = b.postsByPath(ctx); err != nil {
+		return
+	}
+
+	batch := scanBatch{b: b}
+	if batch.rules, err = b.ruleSet(ctx); err != nil {
+		return
+	}
+	defer batch.rollback()
+
+	seen := map[string]bool{}
+	visit := func(path string, entry fs.DirEntry) (err error) {
+		seen[path] = true
+		report.Path = path
+		report.Scanned++
+
+		if post, ok := posts[path]; ok {
+			var refreshed bool
+			if refreshed, err = batch.refresh(ctx, post, entry); err != nil {
+				return
+			}
+			if refreshed {
+				report.Refreshed++
+			} else {
+				report.Skipped++
+			}
+		} else if known[path] {
+			report.Skipped++
+		} else {
+			// a post is still added when its sidecars can't be read
+			tags, sidecarErr := ReadSidecarTags(path)
+			if sidecarErr != nil {
+				report.Errors = append(report.Errors, sidecarErr)
+			}
+
+			var result scanResult
+			if result, err = batch.add(ctx, path, entry, tags, options.Policy); err != nil {
+				return
+			}
+			switch result {
+			case scanAdded:
+				report.Added++
+			case scanMerged:
+				report.Merged++
+			default:
+				report.Skipped++
+			}
+		}
+
+		if batch.size >= options.BatchSize {
+			if err = batch.commit(ctx); err != nil {
+				return
+			}
+		}
+
+		if options.Progress != nil {
+			options.Progress(report.ScanProgress)
+		}
+
+		return
+	}
+
+	for _, root := range roots {
+		if err = walkFiles(ctx, root, visit); err != nil {
+			return
+		}
+	}
+
+	if err = batch.commit(ctx); err != nil {
+		return
+	}
+
+	report.Missing, err = b.missingPosts(ctx, roots, seen)
+
+	return
+}
+
+// call visit with every regular file below root (or root itself, if it is a
+// file) in lexical order, skipping hidden files and directories and sidecars;
+// the listing of each directory is read once and tells which are sidecars
+func walkFiles(ctx context.Context, root string, visit func(path string, entry fs.DirEntry) error) (err error) {
+	var info fs.FileInfo
+	if info, err = os.Lstat(root); err != nil {
+		return
+	}
+	if !info.IsDir() {
+		if info.Mode().IsRegular() && !IsSidecar(root) {
+			err = visit(root, fs.FileInfoToDirEntry(info))
+		}
+		return
+	}
+
+	return walkDir(ctx, root, visit)
+}
+
+func walkDir(ctx context.Context, dir string, visit func(path string, entry fs.DirEntry) error) (err error) {
+	var entries []fs.DirEntry
+	if entries, err = os.ReadDir(dir); err != nil {
+		return
+	}
+	sidecars := sidecarNames(entries)
+
+	for _, entry := range entries {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+		switch {
+		case strings.HasPrefix(name, "."):
+		case entry.IsDir():
+			err = walkDir(ctx, path, visit)
+		case entry.Type().IsRegular() && !sidecars[name]:
+			err = visit(path, entry)
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// paths of every post and location
+func (b *Booru) knownPaths(ctx context.Context) (known map[string]bool, err error) {
+	var paths []string
+	if paths, err = queryStrings(ctx, b.db, StatementQueryPaths); err != nil {
+		return
+	}
+
+	known = make(map[string]bool, len(paths))
+	for _, path := range paths {
+		known[path] = true
+	}
+
+	return
+}
+
+// every post, by path
+func (b *Booru) postsByPath(ctx context.Context) (posts map[string]Post, err error) {
+	var list []Post
+	if list, err = scanPosts(ctx, b.db, StatementQueryEveryPostPath); err != nil {
+		return
+	}
+
+	posts = make(map[string]Post, len(list))
+	for _, post := range list {
+		posts[post.Post] = post
+	}
+
+	return
+}
+
+// posts below the roots which were not seen by the scan, and which aren't
+// at any of their other locations either
+func (b *Booru) missingPosts(ctx context.Context, roots []string, seen map[string]bool) (missing []Post, err error) {
+	var locations map[int64][]string
+	if locations, err = postLocations(ctx, b.db); err != nil {
+		return
+	}
+
+	exists := func(path string) bool {
+		if seen[path] {
+			return true
+		}
+		_, statErr := os.Stat(path)
+		return !os.IsNotExist(statErr)
+	}
+
+	var rows *sql.Rows
+	if rows, err = b.db.QueryContext(ctx, StatementQueryEveryPostPath); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var post Post
+		if err = rows.Scan(post.fields()...); err != nil {
+			return
+		}
+
+		if !underRoots(post.Post, roots) || exists(post.Post) {
+			continue
+		}
+		located := false
+		for _, location := range locations[post.ID] {
+			if located = exists(location); located {
+				break
+			}
+		}
+		if !located {
+			missing = append(missing, post)
+		}
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+func underRoots(path string, roots []string) bool {
+	for _, root := range roots {
+		if rel, err := filepath.Rel(root, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// a transaction holding up to BatchSize added posts
+type scanBatch struct {
+	b           *Booru
+	rules       ruleSet
+	transaction *sql.Tx
+	size        int
+	writes      []memoryWrite
+	stale       []Metadata // the contents of refreshed posts, whose thumbnails may go
+}
+
+// what became of a scanned file
+type scanResult int
+
+const (
+	scanSkipped scanResult = iota
+	scanAdded              // a new post or location
+	scanMerged             // a duplicate whose tags went to the existing post
+)
+
+func (batch *scanBatch) add(ctx context.Context, path string, entry fs.DirEntry, sidecarTags []string, policy DuplicatePolicy) (result scanResult, err error) {
+	var info fs.FileInfo
+	if info, err = entry.Info(); err != nil {
+		return
+	}
+
+	var metadata Metadata
+	if metadata, err = ReadMetadata(path); err != nil {
+		return
+	}
+
+	tags := append(batch.rules.tags(path), sidecarTags...)
+
+	timestamp := info.ModTime()
+	if taken, exifErr := ExifTime(path); exifErr == nil {
+		timestamp = taken
+	}
+
+	if batch.transaction == nil {
+		if batch.transaction, err = batch.b.db.BeginTx(ctx, nil); err != nil {
+			return
+		}
+	}
+
+	var write memoryWrite
+	if _, write, err = batch.b.addPostPolicy(ctx, batch.transaction, path, timestamp, metadata, policy, tags); err == ErrorDuplicatePost {
+		return scanSkipped, nil
+	} else if err != nil {
+		return
+	}
+
+	batch.size++
+	batch.writes = append(batch.writes, write)
+
+	if write.post == nil && policy == DuplicateMerge {
+		return scanMerged, nil
+	}
+	return scanAdded, nil
+}
+
+// read the metadata of a known post again if its file's size has changed,
+// or it was never read
+func (batch *scanBatch) refresh(ctx context.Context, post Post, entry fs.DirEntry) (refreshed bool, err error) {
+	var info fs.FileInfo
+	if info, err = entry.Info(); err != nil {
+		return
+	}
+	if post.Hash != "" && info.Size() == post.Size {
+		return
+	}
+
+	var metadata Metadata
+	if metadata, err = ReadMetadata(post.Post); err != nil || metadata == post.Metadata {
+		return
+	}
+
+	if batch.transaction == nil {
+		if batch.transaction, err = batch.b.db.BeginTx(ctx, nil); err != nil {
+			return
+		}
+	}
+
+	if _, err = batch.transaction.ExecContext(ctx, StatementUpdatePostMetadata, metadata.Hash, metadata.Size, metadata.MIME, metadata.Width, metadata.Height, metadata.phash(), post.ID); err != nil {
+		return
+	}
+
+	// indexes hold post metadata, so every index of the post is stale
+	posts := []Post{post}
+	if err = batch.b.loadTags(ctx, batch.transaction, posts); err != nil {
+		return
+	}
+	changed := posts[0]
+	changed.Metadata = metadata
+	write := memoryWrite{id: post.ID, post: &changed}
+	for _, tag := range changed.Tags {
+		write.tagged = append(write.tagged, tag.Tag)
+	}
+
+	batch.size++
+	batch.writes = append(batch.writes, write)
+	batch.stale = append(batch.stale, post.Metadata)
+
+	return true, nil
+}
+
+func (batch *scanBatch) commit(ctx context.Context) (err error) {
+	if batch.transaction == nil {
+		return
+	}
+
+	err = batch.transaction.Commit()
+	batch.transaction = nil
+	batch.size = 0
+	if err != nil {
+		return
+	}
+
+	var stale []string
+	for _, write := range batch.writes {
+		stale = append(stale, write.stale()...)
+	}
+	batch.b.memory.apply(batch.writes...)
+	batch.writes = nil
+
+	if err = batch.b.invalidateIndexes(stale...); err != nil {
+		return
+	}
+
+	for _, metadata := range batch.stale {
+		if err = batch.b.pruneThumbnail(ctx, metadata); err != nil {
+			return
+		}
+	}
+	batch.stale = nil
+
+	return
+}
+
+func (batch *scanBatch) rollback() {
+	if batch.transaction != nil {
+		batch.transaction.Rollback()
+	}
+}
@@ -0,0 +1,76 @@
+package booru
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// a changed file gets its metadata read again by the next scan, and a new
+// thumbnail in place of the old one
+func TestScanRefresh(t *testing.T) {
+	ctx := context.Background()
+
+	b := testBooru(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.png")
+
+	writeImage := func(size int) {
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if err = png.Encode(file, image.NewGray(image.Rect(0, 0, size, size))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scan := func() ScanReport {
+		report, err := b.Scan(ctx, []string{dir}, ScanOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	writeImage(4)
+	if report := scan(); report.Added != 1 {
+		t.Fatalf("first scan: got %+v, want one post added", report.ScanProgress)
+	}
+	old, err := b.Thumbnail(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report := scan(); report.Refreshed != 0 || report.Skipped != 1 {
+		t.Errorf("unchanged scan: got %+v, want the post skipped", report.ScanProgress)
+	}
+
+	writeImage(8)
+	if report := scan(); report.Refreshed != 1 {
+		t.Errorf("changed scan: got %+v, want the post refreshed", report.ScanProgress)
+	}
+
+	post, err := b.GetPost(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Width != 8 {
+		t.Errorf("got width %d, want 8", post.Width)
+	}
+
+	thumb, err := b.Thumbnail(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumb == old {
+		t.Errorf("got the old thumbnail %s", old)
+	}
+	if _, err = os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("old thumbnail: got %v, want it removed", err)
+	}
+}
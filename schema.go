@@ -0,0 +1,142 @@
+package booru
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// database statements
+const (
+	StatementCreateSchema     = "create table schema (version integer not null)"
+	StatementQuerySchema      = "select version from schema"
+	StatementInsertSchema     = "insert into schema (version) values (?)"
+	StatementUpdateSchema     = "update schema set version = ?"
+	StatementQueryTableExists = "select count(*) from sqlite_master where type = 'table' and name = ?"
+)
+
+// errors
+var (
+	ErrorSchemaTooNew = errors.New("database schema is newer than this booru")
+)
+
+// A Migration upgrades the database schema by one version.
+type Migration func(context.Context, *sql.Tx) error
+
+// A Migration that executes each statement in order.
+func MigrationStatements(statements ...string) Migration {
+	return func(ctx context.Context, transaction *sql.Tx) (err error) {
+		for _, statement := range statements {
+			if _, err = transaction.ExecContext(ctx, statement); err != nil {
+				return
+			}
+		}
+		return
+	}
+}
+
+// migrations[i] upgrades a database from schema version i to i+1; append only.
+// Columns read from the files behind posts (metadata and perceptual hashes)
+// are added empty, and filled in by RefreshMetadata.
+var migrations = []Migration{
+	MigrationStatements(StatementCreatePosts, StatementCreateTags, StatementCreateRelations),
+	MigrationStatements(StatementCreateAliases),
+	MigrationStatements(StatementCreateImplications),
+	MigrationStatements(StatementAddTagNamespace, StatementFillTagNamespace, StatementCreateNamespaces, StatementFillNamespaces),
+	MigrationStatements(StatementAddPostHash, StatementAddPostSize, StatementAddPostMIME, StatementAddPostWidth, StatementAddPostHeight),
+	MigrationStatements(StatementCreatePostHashIndex, StatementCreateLocations),
+	MigrationStatements(StatementAddPostPHash),
+	MigrationStatements(StatementCreateRules),
+	MigrationStatements(StatementCreateRelationsTagIndex),
+}
+
+// The schema version this booru reads and writes.
+var SchemaVersion = len(migrations)
+
+func tableExists(ctx context.Context, transaction *sql.Tx, table string) (exists bool, err error) {
+	var count int
+	if err = transaction.QueryRowContext(ctx, StatementQueryTableExists, table).Scan(&count); err != nil {
+		return
+	}
+	exists = count > 0
+	return
+}
+
+func schemaVersion(ctx context.Context, transaction *sql.Tx) (version int, err error) {
+	var exists bool
+	if exists, err = tableExists(ctx, transaction, "schema"); err != nil {
+		return
+	}
+	if exists {
+		err = transaction.QueryRowContext(ctx, StatementQuerySchema).Scan(&version)
+		return
+	}
+
+	// databases created before versioning have the first schema
+	if exists, err = tableExists(ctx, transaction, "posts"); err != nil {
+		return
+	}
+	if exists {
+		version = 1
+	}
+
+	return
+}
+
+// Get the schema version of the database.
+func (b *Booru) DatabaseVersion(ctx context.Context) (version int, err error) {
+	var transaction *sql.Tx
+	if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer transaction.Rollback()
+
+	return schemaVersion(ctx, transaction)
+}
+
+// Upgrade the database to SchemaVersion in a single transaction. Fails with
+// ErrorSchemaTooNew if the database was written by a newer booru.
+func (b *Booru) Migrate(ctx context.Context) (err error) {
+	var transaction *sql.Tx
+	if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer transaction.Rollback()
+
+	var version int
+	if version, err = schemaVersion(ctx, transaction); err != nil {
+		return
+	}
+
+	if version > SchemaVersion {
+		return ErrorSchemaTooNew
+	} else if version == SchemaVersion {
+		return
+	}
+
+	for _, migrate := range migrations[version:] {
+		if err = migrate(ctx, transaction); err != nil {
+			return
+		}
+	}
+
+	var exists bool
+	if exists, err = tableExists(ctx, transaction, "schema"); err != nil {
+		return
+	}
+	if exists {
+		_, err = transaction.ExecContext(ctx, StatementUpdateSchema, SchemaVersion)
+	} else if _, err = transaction.ExecContext(ctx, StatementCreateSchema); err == nil {
+		_, err = transaction.ExecContext(ctx, StatementInsertSchema, SchemaVersion)
+	}
+	if err != nil {
+		return
+	}
+
+	if err = transaction.Commit(); err != nil {
+		return
+	}
+
+	// indexes are written in terms of the old schema
+	return b.clearIndexes(ctx)
+}
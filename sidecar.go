@@ -0,0 +1,297 @@
+package booru
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A SidecarFormat is a file next to a post listing its tags.
+type SidecarFormat int
+
+const (
+	SidecarText SidecarFormat = iota // file.jpg.txt: one tag per line (or comma separated)
+	SidecarJSON                      // file.jpg.json or file.json: ["tag", ...] or {"tags": ...}
+	SidecarXMP                       // file.jpg.xmp or file.xmp: dc:subject
+)
+
+// errors
+var (
+	ErrorUnknownSidecarFormat = errors.New("unknown sidecar format")
+)
+
+var sidecarExtensions = map[string]SidecarFormat{
+	".txt":  SidecarText,
+	".json": SidecarJSON,
+	".xmp":  SidecarXMP,
+}
+
+const (
+	xmlnsDC  = "http://purl.org/dc/elements/1.1/"
+	xmlnsRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+)
+
+func (format SidecarFormat) extension() string {
+	for ext, f := range sidecarExtensions {
+		if f == format {
+			return ext
+		}
+	}
+	return ""
+}
+
+// the sidecar files a post may have, in the order they are read
+func sidecarPaths(path string) (paths []string) {
+	stem := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, ext := range []string{".txt", ".json", ".xmp"} {
+		paths = append(paths, path+ext)
+		if stem != path && ext != ".txt" {
+			paths = append(paths, stem+ext)
+		}
+	}
+	return
+}
+
+// Whether a file is the sidecar of another file rather than a post itself.
+func IsSidecar(path string) bool {
+	if _, ok := sidecarExtensions[filepath.Ext(path)]; !ok {
+		return false
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return false
+	}
+	return sidecarNames(entries)[filepath.Base(path)]
+}
+
+// the names in a directory listing which are sidecars of other files in it
+func sidecarNames(entries []fs.DirEntry) (sidecars map[string]bool) {
+	files := map[string]bool{} // file.jpg, for file.jpg.txt
+	stems := map[string]bool{} // file, for file.json next to file.jpg
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		if entry.Type().IsRegular() {
+			files[name] = true
+		}
+		if _, sidecar := sidecarExtensions[ext]; !sidecar {
+			stems[strings.TrimSuffix(name, ext)] = true
+		}
+	}
+
+	sidecars = map[string]bool{}
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		if _, ok := sidecarExtensions[ext]; !ok {
+			continue
+		}
+		if stem := strings.TrimSuffix(name, ext); files[stem] || (ext != ".txt" && stems[stem]) {
+			sidecars[name] = true
+		}
+	}
+
+	return
+}
+
+// Read the tags from every sidecar of a post. Whitespace within a tag becomes
+// an underscore, and tags in reserved namespaces are dropped. A sidecar which
+// can't be read doesn't stop the rest being read; the first such error is
+// returned along with their tags.
+func ReadSidecarTags(path string) (tags []string, err error) {
+	seen := map[string]bool{}
+
+	for _, sidecar := range sidecarPaths(path) {
+		data, readErr := os.ReadFile(sidecar)
+		if os.IsNotExist(readErr) {
+			continue
+		}
+
+		var read []string
+		if readErr == nil {
+			switch sidecarExtensions[filepath.Ext(sidecar)] {
+			case SidecarText:
+				read = parseTextSidecar(data)
+			case SidecarJSON:
+				read, readErr = parseJSONSidecar(data)
+			case SidecarXMP:
+				read, readErr = parseXMPSidecar(data)
+			}
+		}
+		if readErr != nil {
+			if err == nil {
+				err = fmt.Errorf("%s: %w", sidecar, readErr)
+			}
+			continue
+		}
+
+		for _, tag := range read {
+			tag = strings.Join(strings.Fields(tag), "_")
+			if namespace, _ := SplitTag(tag); tag == "" || seen[tag] || IsReservedNamespace(namespace) {
+				continue
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	return
+}
+
+func parseTextSidecar(data []byte) (tags []string) {
+	for _, line := range strings.Split(string(data), "\n") {
+		tags = append(tags, strings.Split(line, ",")...)
+	}
+	return
+}
+
+func parseJSONSidecar(data []byte) (tags []string, err error) {
+	if err = json.Unmarshal(data, &tags); err == nil {
+		return
+	}
+
+	var object struct {
+		Tags json.RawMessage `json:"tags"`
+	}
+	if err = json.Unmarshal(data, &object); err != nil || object.Tags == nil {
+		return
+	}
+
+	// either a list of tags or a space separated string of them
+	if err = json.Unmarshal(object.Tags, &tags); err == nil {
+		return
+	}
+	var str string
+	if err = json.Unmarshal(object.Tags, &str); err != nil {
+		return
+	}
+	return strings.Fields(str), nil
+}
+
+func parseXMPSidecar(data []byte) (tags []string, err error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+
+	inSubject, inItem := false, false
+	for {
+		var token xml.Token
+		if token, err = decoder.Token(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Space == xmlnsDC && t.Name.Local == "subject" {
+				inSubject = true
+			} else if inSubject && t.Name.Space == xmlnsRDF && t.Name.Local == "li" {
+				inItem = true
+				tags = append(tags, "")
+			}
+		case xml.EndElement:
+			if t.Name.Space == xmlnsDC && t.Name.Local == "subject" {
+				inSubject = false
+			} else if t.Name.Space == xmlnsRDF && t.Name.Local == "li" {
+				inItem = false
+			}
+		case xml.CharData:
+			if inItem {
+				tags[len(tags)-1] += string(t)
+			}
+		}
+	}
+}
+
+// Write the tags of every tagged post to a sidecar next to it (file.jpg.txt,
+// file.jpg.json or file.jpg.xmp), replacing any existing sidecar of that name.
+func (b *Booru) ExportSidecars(ctx context.Context, format SidecarFormat) (written int64, err error) {
+	ext := format.extension()
+	if ext == "" {
+		err = ErrorUnknownSidecarFormat
+		return
+	}
+
+	var transaction *sql.Tx
+	if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer transaction.Rollback()
+
+	var posts []Post
+	if posts, err = scanPosts(ctx, transaction, StatementQueryEveryPostPath); err != nil {
+		return
+	}
+	if err = b.loadTags(ctx, transaction, posts); err != nil {
+		return
+	}
+
+	for _, post := range posts {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		if len(post.Tags) == 0 {
+			continue
+		}
+
+		tags := make([]string, len(post.Tags))
+		for i, tag := range post.Tags {
+			tags[i] = tag.Tag
+		}
+
+		var data []byte
+		if data, err = formatSidecar(format, tags); err != nil {
+			return
+		}
+		if err = os.WriteFile(post.Post+ext, data, 0644); err != nil {
+			return
+		}
+		written++
+	}
+
+	return
+}
+
+func formatSidecar(format SidecarFormat, tags []string) (data []byte, err error) {
+	switch format {
+	case SidecarText:
+		return []byte(strings.Join(tags, "\n") + "\n"), nil
+	case SidecarJSON:
+		return json.Marshal(struct {
+			Tags []string `json:"tags"`
+		}{tags})
+	case SidecarXMP:
+		var buffer bytes.Buffer
+		buffer.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="` + xmlnsRDF + `">
+  <rdf:Description rdf:about="" xmlns:dc="` + xmlnsDC + `">
+   <dc:subject>
+    <rdf:Bag>
+`)
+		for _, tag := range tags {
+			buffer.WriteString("     <rdf:li>")
+			xml.EscapeText(&buffer, []byte(tag))
+			buffer.WriteString("</rdf:li>\n")
+		}
+		buffer.WriteString(`    </rdf:Bag>
+   </dc:subject>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+`)
+		return buffer.Bytes(), nil
+	}
+
+	return nil, ErrorUnknownSidecarFormat
+}
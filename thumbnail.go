@@ -0,0 +1,172 @@
+package booru
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// database statements
+const (
+	StatementUpdatePostMetadata = "update posts set hash = ?, size = ?, mime = ?, width = ?, height = ?, phash = ? where id = ?"
+	StatementCountHashPosts     = "select count(*) from posts where hash = ?"
+)
+
+// errors
+var (
+	ErrorNoThumbnail = errors.New("post has no thumbnail")
+)
+
+// thumbnails fit within a ThumbnailSize square
+const ThumbnailSize = 192
+
+// thumbnails are cached in this subdirectory of the index directory
+const thumbnailDirectory = "thumbs"
+
+// thumbnails are named after the contents of the post, so a changed file
+// gets a new thumbnail; formats with transparency get png thumbnails
+func (b *Booru) thumbnailPath(metadata Metadata) string {
+	ext := ".jpg"
+	if metadata.MIME == "image/png" || metadata.MIME == "image/gif" {
+		ext = ".png"
+	}
+	return filepath.Join(b.index, thumbnailDirectory, metadata.Hash+ext)
+}
+
+// Get the path of a thumbnail for a post, generating it if needed. The
+// thumbnail is named after the post's recorded contents, so one made from
+// other contents is never served; a file which has changed gets new metadata,
+// and with it a new thumbnail, when it is scanned again.
+func (b *Booru) Thumbnail(ctx context.Context, resource string) (path string, err error) {
+	var post Post
+	if post, err = b.GetPost(ctx, resource); err != nil {
+		return
+	}
+	if post.Hash == "" || post.Width == 0 {
+		err = ErrorNoThumbnail
+		return
+	}
+
+	path = b.thumbnailPath(post.Metadata)
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		return
+	}
+
+	err = writeThumbnail(post.Post, path)
+
+	return
+}
+
+// remove the thumbnail of a post's old contents, once no post has them
+func (b *Booru) pruneThumbnail(ctx context.Context, metadata Metadata) (err error) {
+	if metadata.Hash == "" {
+		return
+	}
+
+	var count int64
+	if err = b.db.QueryRowContext(ctx, StatementCountHashPosts, metadata.Hash).Scan(&count); err != nil || count > 0 {
+		return
+	}
+
+	if err = os.Remove(b.thumbnailPath(metadata)); os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+func writeThumbnail(source, path string) (err error) {
+	var file *os.File
+	if file, err = os.Open(source); err != nil {
+		return
+	}
+	defer file.Close()
+
+	// animated gifs decode to their first frame
+	var img image.Image
+	if img, _, err = image.Decode(file); err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+
+	var thumb *os.File
+	if thumb, err = os.CreateTemp(filepath.Dir(path), ".thumb-*"); err != nil {
+		return
+	}
+	defer os.Remove(thumb.Name())
+	defer thumb.Close()
+
+	if err = encodeThumbnail(thumb, shrink(img, ThumbnailSize), filepath.Ext(path)); err != nil {
+		return
+	}
+
+	if err = thumb.Close(); err != nil {
+		return
+	}
+
+	return os.Rename(thumb.Name(), path)
+}
+
+func encodeThumbnail(w io.Writer, img image.Image, ext string) error {
+	if ext == ".png" {
+		return png.Encode(w, img)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+}
+
+// scale an image down to fit within a size square, averaging the source pixels
+// covered by each thumbnail pixel
+func shrink(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+
+	tw, th := size, size
+	if w > h {
+		th = h * size / w
+	} else {
+		tw = w * size / h
+	}
+	if tw == 0 {
+		tw = 1
+	}
+	if th == 0 {
+		th = 1
+	}
+
+	thumb := image.NewNRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0 := bounds.Min.Y + y*h/th
+		y1 := bounds.Min.Y + (y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0 := bounds.Min.X + x*w/tw
+			x1 := bounds.Min.X + (x+1)*w/tw
+
+			var r, g, b, a uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBA64Model.Convert(img.At(sx, sy)).(color.NRGBA64)
+					r += uint64(c.R)
+					g += uint64(c.G)
+					b += uint64(c.B)
+					a += uint64(c.A)
+				}
+			}
+
+			n := uint64((x1 - x0) * (y1 - y0))
+			thumb.Set(x, y, color.NRGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+
+	return thumb
+}
@@ -10,13 +10,21 @@ import (
 
 // database statements
 const (
-	StatementQueryPost     = "select id, timestamp, post from posts where post = ?"
-	StatementQueryPostTags = "select tags.id, tags.tag from relations join tags on relations.tag = tags.id where relations.post = ?"
+	StatementQueryPost     = "select " + postColumns + " from posts where posts.post = ? or posts.id in (select post from locations where location = ?)"
+	StatementQueryPostTags = "select tags.id, tags.tag, tags.namespace from relations join tags on relations.tag = tags.id where relations.post = ?"
+
+	// followed by a parenthesised list of post ids
+	StatementQueryPostsTags = "select relations.post, tags.id, tags.tag, tags.namespace from relations join tags on relations.tag = tags.id where relations.post in "
 )
 
+// the most posts whose tags are loaded by one statement, within sqlite's
+// limit on the number of parameters
+const postTagsBatch = 500
+
 type Tag struct {
-	ID  int64
-	Tag string
+	ID        int64
+	Tag       string
+	Namespace string
 }
 
 func (t Tag) String() string {
@@ -54,16 +62,15 @@ type Post struct {
 	Time time.Time
 	Post string
 	Tags Tags
+	Metadata
 }
 
 // semantics like strings.Compare
 type PostCompare func(Post, Post) int
 
+// Orders compare by their sort key, then by ID, so that a post which is not
+// in the library (such as one rebuilt from a cursor) still has a place.
 func ComparePostAscending(a, b Post) int {
-	if a.ID == b.ID {
-		return 0
-	}
-
 	if a.Time.Before(b.Time) {
 		return -1
 	} else if a.Time.After(b.Time) {
@@ -76,14 +83,54 @@ func ComparePostAscending(a, b Post) int {
 		return 1
 	}
 
-	// unreachable if data isn't bad
-	panic(nil)
+	return ComparePostID(a, b)
 }
 
 func ComparePostDescending(a, b Post) int {
 	return ComparePostAscending(b, a)
 }
 
+func ComparePostPath(a, b Post) int {
+	if a.Post < b.Post {
+		return -1
+	} else if b.Post < a.Post {
+		return 1
+	}
+
+	return ComparePostID(a, b)
+}
+
+func ComparePostID(a, b Post) int {
+	if a.ID < b.ID {
+		return -1
+	} else if a.ID > b.ID {
+		return 1
+	}
+	return 0
+}
+
+// a shuffled order, the same for every use of the same seed
+func ComparePostRandom(seed int64) PostCompare {
+	key := func(p Post) uint64 {
+		// splitmix64 finalizer
+		z := uint64(seed) ^ uint64(p.ID)*0x9E3779B97F4A7C15
+		z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+		z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+		return z ^ (z >> 31)
+	}
+
+	return func(a, b Post) int {
+		ka, kb := key(a), key(b)
+		if ka < kb {
+			return -1
+		} else if ka > kb {
+			return 1
+		}
+
+		return ComparePostID(a, b)
+	}
+}
+
 func (b *Booru) GetPostTags(ctx context.Context, transaction *sql.Tx, id int64) (tags Tags, err error) {
 	if transaction == nil {
 		if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
@@ -99,7 +146,7 @@ func (b *Booru) GetPostTags(ctx context.Context, transaction *sql.Tx, id int64)
 
 	for rows.Next() {
 		var tag Tag
-		if err = rows.Scan(&tag.ID, &tag.Tag); err != nil {
+		if err = rows.Scan(&tag.ID, &tag.Tag, &tag.Namespace); err != nil {
 			return
 		}
 
@@ -115,6 +162,89 @@ func (b *Booru) GetPostTags(ctx context.Context, transaction *sql.Tx, id int64)
 	return
 }
 
+// Get the tags of many posts at once, keyed by post ID, in a few statements
+// rather than one per post. Posts without tags have no entry.
+func (b *Booru) GetTagsForPosts(ctx context.Context, transaction *sql.Tx, ids []int64) (tags map[int64]Tags, err error) {
+	if transaction == nil {
+		if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
+			return
+		}
+		defer transaction.Rollback()
+	}
+
+	// a post asked for twice would otherwise get its tags twice
+	unique := make([]int64, 0, len(ids))
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	ids = unique
+
+	tags = make(map[int64]Tags, len(ids))
+	for start := 0; start < len(ids); start += postTagsBatch {
+		end := start + postTagsBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if err = queryPostsTags(ctx, transaction, ids[start:end], tags); err != nil {
+			return
+		}
+	}
+
+	for _, t := range tags {
+		sort.Sort(t)
+	}
+
+	return
+}
+
+func queryPostsTags(ctx context.Context, db querier, ids []int64, tags map[int64]Tags) (err error) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	statement := StatementQueryPostsTags + "(?" + strings.Repeat(", ?", len(ids)-1) + ")"
+
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, statement, args...); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var post int64
+		var tag Tag
+		if err = rows.Scan(&post, &tag.ID, &tag.Tag, &tag.Namespace); err != nil {
+			return
+		}
+		tags[post] = append(tags[post], tag)
+	}
+
+	return rows.Err()
+}
+
+// fill in the tags of posts
+func (b *Booru) loadTags(ctx context.Context, transaction *sql.Tx, posts []Post) (err error) {
+	ids := make([]int64, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+
+	var tags map[int64]Tags
+	if tags, err = b.GetTagsForPosts(ctx, transaction, ids); err != nil {
+		return
+	}
+
+	for i := range posts {
+		posts[i].Tags = tags[posts[i].ID]
+	}
+
+	return
+}
+
 func (b *Booru) GetPost(ctx context.Context, resource string) (post Post, err error) {
 	var transaction *sql.Tx
 	transaction, err = b.db.BeginTx(ctx, nil)
@@ -125,8 +255,8 @@ func (b *Booru) GetPost(ctx context.Context, resource string) (post Post, err er
 
 	// get the post itself
 	var row *sql.Row
-	row = transaction.QueryRowContext(ctx, StatementQueryPost, resource)
-	if err = row.Scan(&post.ID, &post.Time, &post.Post); err != nil {
+	row = transaction.QueryRowContext(ctx, StatementQueryPost, resource, resource)
+	if err = row.Scan(post.fields()...); err != nil {
 		return
 	}
 
@@ -0,0 +1,47 @@
+package booru
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// the tags of a page of posts, loaded one post at a time and in batches
+func BenchmarkGetTags(b *testing.B) {
+	ctx := context.Background()
+
+	booru := testBooru(b)
+	ids := seedPosts(b, booru, 2000, func(i int) (tags []string) {
+		for j := 0; j < 10; j++ {
+			tags = append(tags, fmt.Sprintf("tag%d", (i+j*13)%97))
+		}
+		return
+	})
+
+	for _, length := range []int{20, 100, 1000} {
+		page := ids[:length]
+
+		b.Run(fmt.Sprintf("GetPostTags/%d", length), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				transaction, err := booru.db.BeginTx(ctx, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, id := range page {
+					if _, err = booru.GetPostTags(ctx, transaction, id); err != nil {
+						b.Fatal(err)
+					}
+				}
+				transaction.Rollback()
+			}
+		})
+
+		b.Run(fmt.Sprintf("GetTagsForPosts/%d", length), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := booru.GetTagsForPosts(ctx, nil, page); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package booru
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// database statements
+const (
+	StatementQueryPostID         = "select id from posts where id = ?"
+	StatementQueryPostByID       = "select " + postColumns + " from posts where id = ?"
+	StatementQueryPostByPath     = "select id from posts where post = ?"
+	StatementQueryTagID          = "select id from tags where tag = ?"
+	StatementQueryRelation       = "select 1 from relations where post = ? and tag = ?"
+	StatementInsertPost          = "insert into posts (timestamp, post, hash, size, mime, width, height, phash) values (?, ?, ?, ?, ?, ?, ?, ?)"
+	StatementInsertTag           = "insert into tags (tag, namespace) values (?, ?)"
+	StatementInsertRelation      = "insert into relations (post, tag) values (?, ?)"
+	StatementDeleteRelation      = "delete from relations where post = ? and tag = ?"
+	StatementDeletePostRelations = "delete from relations where post = ?"
+	StatementDeletePost          = "delete from posts where id = ?"
+)
+
+// Add a new post, failing with ErrorDuplicatePost if the post (or a post with
+// the same contents) is already known. The post must be a readable file so that
+// its metadata can be recorded.
+func (b *Booru) AddPost(ctx context.Context, post string, timestamp time.Time) (id int64, err error) {
+	return b.AddPostPolicy(ctx, post, timestamp, DuplicateReject)
+}
+
+// Attach tags to a post, creating any tags that do not yet exist. Aliased tags
+// are stored in their canonical form.
+func (b *Booru) TagPost(ctx context.Context, id int64, tags ...string) (err error) {
+	var transaction *sql.Tx
+	if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer transaction.Rollback()
+
+	if err = b.checkPost(ctx, transaction, id); err != nil {
+		return
+	}
+
+	stale := make([]string, len(tags))
+	for i, tag := range tags {
+		if stale[i], err = b.tagPost(ctx, transaction, id, tag); err != nil {
+			return
+		}
+	}
+
+	if err = transaction.Commit(); err != nil {
+		return
+	}
+
+	b.memory.apply(memoryWrite{id: id, tagged: stale})
+	err = b.invalidateIndexes(stale...)
+
+	return
+}
+
+// Detach tags from a post; tags the post does not have are ignored.
+func (b *Booru) UntagPost(ctx context.Context, id int64, tags ...string) (err error) {
+	var transaction *sql.Tx
+	if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer transaction.Rollback()
+
+	if err = b.checkPost(ctx, transaction, id); err != nil {
+		return
+	}
+
+	stale := make([]string, len(tags))
+	for i, tag := range tags {
+		if stale[i], err = b.untagPost(ctx, transaction, id, tag); err != nil {
+			return
+		}
+	}
+
+	if err = transaction.Commit(); err != nil {
+		return
+	}
+
+	b.memory.apply(memoryWrite{id: id, untagged: stale})
+	err = b.invalidateIndexes(stale...)
+
+	return
+}
+
+// Delete a post along with all of its relations.
+func (b *Booru) DeletePost(ctx context.Context, id int64) (err error) {
+	var transaction *sql.Tx
+	if transaction, err = b.db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer transaction.Rollback()
+
+	// the metadata tells which thumbnail was the post's
+	var post Post
+	if err = transaction.QueryRowContext(ctx, StatementQueryPostByID, id).Scan(post.fields()...); err == sql.ErrNoRows {
+		err = ErrorInvalidPostID
+		return
+	} else if err != nil {
+		return
+	}
+
+	var tags Tags
+	if tags, err = b.GetPostTags(ctx, transaction, id); err != nil {
+		return
+	}
+
+	if _, err = transaction.ExecContext(ctx, StatementDeletePostRelations, id); err != nil {
+		return
+	}
+	if _, err = transaction.ExecContext(ctx, StatementDeletePostLocations, id); err != nil {
+		return
+	}
+	if _, err = transaction.ExecContext(ctx, StatementDeletePost, id); err != nil {
+		return
+	}
+
+	if err = transaction.Commit(); err != nil {
+		return
+	}
+
+	write := memoryWrite{id: id, removed: true}
+	for _, tag := range tags {
+		write.untagged = append(write.untagged, tag.Tag)
+	}
+	b.memory.apply(write)
+	if err = b.invalidateIndexes(append(write.untagged, globalIndexTag)...); err != nil {
+		return
+	}
+
+	err = b.pruneThumbnail(ctx, post.Metadata)
+
+	return
+}
+
+func (b *Booru) checkPost(ctx context.Context, transaction *sql.Tx, id int64) (err error) {
+	err = transaction.QueryRowContext(ctx, StatementQueryPostID, id).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = ErrorInvalidPostID
+	}
+	return
+}
+
+// fails with ErrorDuplicatePost if the path is already a post or a location
+func (b *Booru) checkPath(ctx context.Context, transaction *sql.Tx, post string) (err error) {
+	var id int64
+	for _, statement := range []string{StatementQueryPostByPath, StatementQueryLocation} {
+		err = transaction.QueryRowContext(ctx, statement, post).Scan(&id)
+		if err == nil {
+			return ErrorDuplicatePost
+		} else if err != sql.ErrNoRows {
+			return
+		}
+	}
+	return nil
+}
+
+func (b *Booru) addPost(ctx context.Context, transaction *sql.Tx, post string, timestamp time.Time, metadata Metadata) (id int64, err error) {
+	if err = b.checkPath(ctx, transaction, post); err != nil {
+		return
+	}
+
+	var result sql.Result
+	if result, err = transaction.ExecContext(ctx, StatementInsertPost, timestamp, post, metadata.Hash, metadata.Size, metadata.MIME, metadata.Width, metadata.Height, metadata.phash()); err != nil {
+		return
+	}
+
+	return result.LastInsertId()
+}
+
+// look up a tag, creating it (and its namespace) if it does not exist
+func (b *Booru) tagID(ctx context.Context, transaction *sql.Tx, tag string) (id int64, err error) {
+	err = transaction.QueryRowContext(ctx, StatementQueryTagID, tag).Scan(&id)
+	if err != sql.ErrNoRows {
+		return
+	}
+
+	if namespace, _ := SplitTag(tag); IsReservedNamespace(namespace) {
+		err = ErrorReservedNamespace
+		return
+	}
+
+	return insertTag(ctx, transaction, tag)
+}
+
+// create a tag and its namespace
+func insertTag(ctx context.Context, transaction *sql.Tx, tag string) (id int64, err error) {
+	namespace, _ := SplitTag(tag)
+	if namespace != "" {
+		if _, err = transaction.ExecContext(ctx, StatementInsertNamespace, namespace); err != nil {
+			return
+		}
+	}
+
+	var result sql.Result
+	if result, err = transaction.ExecContext(ctx, StatementInsertTag, tag, namespace); err != nil {
+		return
+	}
+
+	return result.LastInsertId()
+}
+
+// returns the canonical tag that was attached
+func (b *Booru) tagPost(ctx context.Context, transaction *sql.Tx, id int64, tag string) (canonical string, err error) {
+	if canonical, err = canonicalTag(ctx, transaction, tag); err != nil {
+		return
+	}
+	tag = canonical
+
+	var tagID int64
+	if tagID, err = b.tagID(ctx, transaction, tag); err != nil {
+		return
+	}
+
+	err = relateTag(ctx, transaction, id, tagID)
+
+	return
+}
+
+// attach a tag to a post, failing with ErrorDuplicateTag if it already is
+func relateTag(ctx context.Context, transaction *sql.Tx, id, tagID int64) (err error) {
+	var exists int
+	err = transaction.QueryRowContext(ctx, StatementQueryRelation, id, tagID).Scan(&exists)
+	if err == nil {
+		err = ErrorDuplicateTag
+		return
+	} else if err != sql.ErrNoRows {
+		return
+	}
+
+	_, err = transaction.ExecContext(ctx, StatementInsertRelation, id, tagID)
+
+	return
+}
+
+// returns the canonical tag that was detached
+func (b *Booru) untagPost(ctx context.Context, transaction *sql.Tx, id int64, tag string) (canonical string, err error) {
+	if canonical, err = canonicalTag(ctx, transaction, tag); err != nil {
+		return
+	}
+	tag = canonical
+
+	var tagID int64
+	err = transaction.QueryRowContext(ctx, StatementQueryTagID, tag).Scan(&tagID)
+	if err == sql.ErrNoRows {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+
+	_, err = transaction.ExecContext(ctx, StatementDeleteRelation, id, tagID)
+
+	return
+}